@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of alertRule.
+const (
+	alertMedianPrice = "median_price"
+	alertCount       = "count"
+	alertNewListing  = "new_listing"
+)
+
+// alertRule is one alert from --alerts.file, like "the median price of 2
+// bedroom listings in Pyrmont is above 700".
+type alertRule struct {
+	Name string `json:"name"`
+	// Module is the search the rule applies to, or every module if empty.
+	Module string `json:"module"`
+	// When is what's compared: median_price, count, or new_listing for each
+	// new listing's price.
+	When  string   `json:"when"`
+	Above *float64 `json:"above"`
+	Below *float64 `json:"below"`
+	// The rule only looks at listings matching these, if they're set.
+	PropertyType string   `json:"propertyType"`
+	Suburb       string   `json:"suburb"`
+	Bedrooms     *float32 `json:"bedrooms"`
+}
+
+// alerts evaluates alert rules against the results of each search, and
+// turns them into events for the notifiers. Rules on median prices and
+// counts notify when they start and stop firing; new_listing rules notify for
+// each matching new listing.
+type alerts struct {
+	path string
+
+	mu     sync.Mutex
+	rules  []alertRule
+	firing map[[2]string]bool // By rule name and search.
+	gauge  *prometheus.GaugeVec
+}
+
+// loadAlerts reads alert rules from path, a JSON array of alertRule.
+func loadAlerts(path string) (*alerts, error) {
+	rules, err := readAlertRules(path)
+	if err != nil {
+		return nil, err
+	}
+	return &alerts{
+		path:   path,
+		rules:  rules,
+		firing: map[[2]string]bool{},
+		gauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "domain_alert_firing",
+			Help: "Whether an alert rule is firing for a search, 1 if it is.",
+		}, []string{"alert", "search"}),
+	}, nil
+}
+
+// reload reads the rules again. Rules that are still there keep firing, and
+// rules that aren't stop, without notifying.
+func (a *alerts) reload() error {
+	rules, err := readAlertRules(a.path)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, r := range rules {
+		names[r.Name] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = rules
+	for k := range a.firing {
+		if !names[k[0]] {
+			delete(a.firing, k)
+			a.gauge.DeleteLabelValues(k[0], k[1])
+		}
+	}
+	return nil
+}
+
+func readAlertRules(path string) ([]alertRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []alertRule
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %v", path, err)
+	}
+	names := map[string]bool{}
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("alert %d has no name", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("alert %q is defined twice", r.Name)
+		}
+		names[r.Name] = true
+		switch r.When {
+		case alertMedianPrice, alertCount, alertNewListing:
+		default:
+			return nil, fmt.Errorf("alert %q: unknown when %q, want median_price, count or new_listing", r.Name, r.When)
+		}
+		if r.Above == nil && r.Below == nil && r.When != alertNewListing {
+			return nil, fmt.Errorf("alert %q needs above or below", r.Name)
+		}
+	}
+	return rules, nil
+}
+
+// Describe implements prometheus.Collector.
+func (a *alerts) Describe(ch chan<- *prometheus.Desc) {
+	a.gauge.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (a *alerts) Collect(ch chan<- prometheus.Metric) {
+	a.gauge.Collect(ch)
+}
+
+// matches reports whether a listing is one the rule looks at.
+func (r alertRule) matches(l listingSummary) bool {
+	return (r.PropertyType == "" || strings.EqualFold(r.PropertyType, l.PropertyType)) &&
+		(r.Suburb == "" || strings.EqualFold(r.Suburb, l.Suburb)) &&
+		(r.Bedrooms == nil || *r.Bedrooms == l.Bedrooms)
+}
+
+// breached returns how v breaches the rule's thresholds, like "above 700", or
+// "" if it doesn't.
+func (r alertRule) breached(v float64) string {
+	if r.Above != nil && v > *r.Above {
+		return fmt.Sprintf("above %v", *r.Above)
+	}
+	if r.Below != nil && v < *r.Below {
+		return fmt.Sprintf("below %v", *r.Below)
+	}
+	return ""
+}
+
+// evaluate checks the rules for a search that just found listings, with
+// events being the changes since it last ran, and returns the alerts to
+// send. Only modules are checked, as clients can make up any number of ad-hoc
+// searches, each of which would add series to domain_alert_firing.
+func (a *alerts) evaluate(key string, listings []domain.SearchResult, events []listingEvent, now time.Time) []listingEvent {
+	if a == nil || !strings.HasPrefix(key, "module:") {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var fired []listingEvent
+	for _, r := range a.rules {
+		if r.Module != "" && key != "module:"+r.Module {
+			continue
+		}
+		if r.When == alertNewListing {
+			for _, e := range events {
+				if e.Type != eventNew || !r.matches(e.Listing) {
+					continue
+				}
+				how := "new listing"
+				if r.Above != nil || r.Below != nil {
+					if e.Price == 0 {
+						continue
+					}
+					if how = r.breached(e.Price); how == "" {
+						continue
+					}
+					how = fmt.Sprintf("new listing at %v, %s", e.Price, how)
+				}
+				fired = append(fired, listingEvent{
+					Type:    eventAlert,
+					Search:  key,
+					Time:    now,
+					Listing: e.Listing,
+					Price:   e.Price,
+					Alert:   fmt.Sprintf("%s: %s: %s", r.Name, how, e.Listing.Address),
+				})
+			}
+			continue
+		}
+		var matched []listingSummary
+		var prices []float64
+		for _, l := range listings {
+			s := summarize(l)
+			if !r.matches(s) {
+				continue
+			}
+			matched = append(matched, s)
+			if s.Price != 0 {
+				prices = append(prices, s.Price)
+			}
+		}
+		var v float64
+		var ok bool
+		switch r.When {
+		case alertCount:
+			v, ok = float64(len(matched)), true
+		case alertMedianPrice:
+			v, ok = collector.Median(prices), len(prices) > 0
+		}
+		if !ok {
+			// No prices to compare, so leave the alert as it was.
+			continue
+		}
+		k := [2]string{r.Name, key}
+		how := r.breached(v)
+		firing := how != ""
+		if firing != a.firing[k] {
+			msg := fmt.Sprintf("%s: %s %v is %s", r.Name, strings.ReplaceAll(r.When, "_", " "), v, how)
+			if !firing {
+				msg = fmt.Sprintf("%s: resolved, %s is %v", r.Name, strings.ReplaceAll(r.When, "_", " "), v)
+			}
+			fired = append(fired, listingEvent{Type: eventAlert, Search: key, Time: now, Alert: msg})
+		}
+		a.firing[k] = firing
+		g := 0.0
+		if firing {
+			g = 1
+		}
+		a.gauge.WithLabelValues(r.Name, key).Set(g)
+	}
+	return fired
+}
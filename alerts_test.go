@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeAlerts(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadAlertRules(t *testing.T) {
+	for _, tc := range []struct {
+		name, contents, wantErr string
+	}{
+		{name: "ok", contents: `[{"name": "dear", "when": "median_price", "above": 700}, {"name": "new", "when": "new_listing"}]`},
+		{name: "no name", contents: `[{"when": "count", "above": 1}]`, wantErr: "has no name"},
+		{name: "twice", contents: `[{"name": "a", "when": "count", "above": 1}, {"name": "a", "when": "count", "below": 1}]`, wantErr: "defined twice"},
+		{name: "unknown when", contents: `[{"name": "a", "when": "mean_price", "above": 1}]`, wantErr: "unknown when"},
+		{name: "no threshold", contents: `[{"name": "a", "when": "count"}]`, wantErr: "needs above or below"},
+		{name: "unknown field", contents: `[{"name": "a", "when": "count", "abve": 1}]`, wantErr: "unknown field"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readAlertRules(writeAlerts(t, tc.contents))
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("readAlertRules() = %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("readAlertRules() = %v, want an error with %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func alertListing(id int32, suburb string, bedrooms float32, price int32) domain.SearchResult {
+	r := testListing(id, price)
+	r.Listing.PropertyDetails.Suburb = suburb
+	r.Listing.PropertyDetails.Bedrooms = bedrooms
+	r.Listing.PropertyDetails.DisplayableAddress = "1 Harris St"
+	return r
+}
+
+func TestAlertsEvaluate(t *testing.T) {
+	a, err := loadAlerts(writeAlerts(t, `[
+		{"name": "dear", "module": "pyrmont", "when": "median_price", "above": 700, "bedrooms": 2},
+		{"name": "few", "when": "count", "below": 2, "suburb": "pyrmont"},
+		{"name": "bargain", "when": "new_listing", "below": 600}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		key      string
+		listings []domain.SearchResult
+		events   []listingEvent
+		want     []string
+	}{
+		{
+			name:     "nothing firing",
+			key:      "module:pyrmont",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 650), alertListing(2, "Pyrmont", 2, 700), alertListing(3, "Pyrmont", 1, 900)},
+		},
+		{
+			name:     "starts firing",
+			key:      "module:pyrmont",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 750), alertListing(2, "Pyrmont", 2, 800)},
+			want:     []string{"dear: median price 775 is above 700"},
+		},
+		{
+			name:     "keeps firing without notifying again",
+			key:      "module:pyrmont",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 750), alertListing(2, "Pyrmont", 2, 900)},
+		},
+		{
+			name:     "no prices leaves it firing",
+			key:      "module:pyrmont",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 0), alertListing(2, "Pyrmont", 2, 0)},
+		},
+		{
+			name:     "resolves, and another starts",
+			key:      "module:pyrmont",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 650)},
+			want:     []string{"dear: resolved, median price is 650", "few: count 1 is below 2"},
+		},
+		{
+			name:     "new listings",
+			key:      "module:ultimo",
+			listings: []domain.SearchResult{alertListing(4, "Ultimo", 1, 550), alertListing(5, "Ultimo", 1, 650), alertListing(6, "Ultimo", 1, 0)},
+			events: []listingEvent{
+				{Type: eventNew, Listing: summarize(alertListing(4, "Ultimo", 1, 550)), Price: 550},
+				{Type: eventNew, Listing: summarize(alertListing(5, "Ultimo", 1, 650)), Price: 650},
+				{Type: eventNew, Listing: summarize(alertListing(6, "Ultimo", 1, 0))},
+				{Type: eventPriceDrop, Listing: summarize(alertListing(7, "Ultimo", 1, 500)), Price: 500},
+			},
+			// No listings in Pyrmont is fewer than 2 too.
+			want: []string{"few: count 0 is below 2", "bargain: new listing at 550, below 600: 1 Harris St"},
+		},
+		{
+			name:     "ad-hoc searches aren't checked",
+			key:      "search:abc",
+			listings: []domain.SearchResult{alertListing(1, "Pyrmont", 2, 900)},
+			events:   []listingEvent{{Type: eventNew, Listing: summarize(alertListing(8, "Pyrmont", 2, 500)), Price: 500}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(time.Hour)
+			var got []string
+			for _, e := range a.evaluate(tc.key, tc.listings, tc.events, now) {
+				if e.Type != eventAlert || e.Search != tc.key || !e.Time.Equal(now) {
+					t.Errorf("evaluate() returned %+v", e)
+				}
+				got = append(got, e.Alert)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("evaluate() alerted %q, want %q", got, tc.want)
+			}
+		})
+	}
+	if err := testutil.CollectAndCompare(a, strings.NewReader(`
+# HELP domain_alert_firing Whether an alert rule is firing for a search, 1 if it is.
+# TYPE domain_alert_firing gauge
+domain_alert_firing{alert="dear",search="module:pyrmont"} 0
+domain_alert_firing{alert="few",search="module:pyrmont"} 1
+domain_alert_firing{alert="few",search="module:ultimo"} 1
+`)); err != nil {
+		t.Error(err)
+	}
+
+	// Dropping a rule stops it firing, without notifying.
+	if err := os.WriteFile(a.path, []byte(`[{"name": "dear", "module": "pyrmont", "when": "median_price", "above": 700}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(a); n != 1 {
+		t.Errorf("%d domain_alert_firing series after reloading, want 1", n)
+	}
+	var nothing *alerts
+	if got := nothing.evaluate("module:pyrmont", nil, nil, now); got != nil {
+		t.Errorf("nil alerts evaluated to %+v", got)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/prometheus/common/model"
+)
+
+// apiListingGroup is one group of listings in the /api/v1/listings response.
+type apiListingGroup struct {
+	PropertyType string  `json:"propertyType"`
+	Suburb       string  `json:"suburb"`
+	Postcode     string  `json:"postcode"`
+	Bedrooms     string  `json:"bedrooms"`
+	Bathrooms    string  `json:"bathrooms"`
+	Carspaces    string  `json:"carspaces"`
+	Count        float64 `json:"count"`
+	MedianPrice  float64 `json:"medianPrice,omitempty"`
+}
+
+// apiListingsResponse is the body of a /api/v1/listings response.
+type apiListingsResponse struct {
+	Module   string            `json:"module,omitempty"`
+	Total    int               `json:"total"`
+	Groups   []apiListingGroup `json:"groups"`
+	Listings []listingSummary  `json:"listings,omitempty"`
+}
+
+// apiListingsHandler serves the same search as /listings, as JSON for things
+// that aren't Prometheus. Pass ?listings=true to also get a summary of each
+// listing.
+func (dc domainCollector) apiListingsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	withListings := false
+	if v := q.Get("listings"); v != "" {
+		var err error
+		if withListings, err = strconv.ParseBool(v); err != nil {
+			dc.badRequest(w, badParam(reasonInvalidValue, "listings", v, "bad listings parameter %q", v))
+			return
+		}
+	}
+	module, rsr, err := dc.searchFromQuery(q, "listings")
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	listings, err := dc.search(r.Context(), module, rsr)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("error searching domain: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIListingsResponse(module, listings, withListings))
+}
+
+// newAPIListingsResponse aggregates the results of a search.
+func newAPIListingsResponse(module string, listings []domain.SearchResult, withListings bool) apiListingsResponse {
+	resp := apiListingsResponse{Module: module, Total: len(listings), Groups: []apiListingGroup{}}
+	for _, g := range collector.GroupListings(listings) {
+		resp.Groups = append(resp.Groups, apiListingGroup{
+			PropertyType: g.Labels[0],
+			Suburb:       g.Labels[1],
+			Postcode:     g.Labels[2],
+			Bedrooms:     g.Labels[3],
+			Bathrooms:    g.Labels[4],
+			Carspaces:    g.Labels[5],
+			Count:        g.Count,
+			MedianPrice:  g.MedianPrice,
+		})
+	}
+	if withListings {
+		for _, l := range listings {
+			resp.Listings = append(resp.Listings, summarize(l))
+		}
+	}
+	return resp
+}
+
+// historyMetrics are the values /api/v1/history can chart, from a
+// historyPoint. median_rent is another name for median_price, which is the
+// weekly rent for rentals.
+var historyMetrics = map[string]func(historyPoint) (float64, bool){
+	"count":        func(p historyPoint) (float64, bool) { return float64(p.Count), true },
+	"median_price": func(p historyPoint) (float64, bool) { return p.MedianPrice, p.MedianPrice != 0 },
+	"median_rent":  func(p historyPoint) (float64, bool) { return p.MedianPrice, p.MedianPrice != 0 },
+}
+
+// apiHistoryPoint is one point in the /api/v1/history response.
+type apiHistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// apiHistoryResponse is the body of a /api/v1/history response.
+type apiHistoryResponse struct {
+	Search string            `json:"search"`
+	Metric string            `json:"metric"`
+	From   time.Time         `json:"from"`
+	To     time.Time         `json:"to"`
+	Points []apiHistoryPoint `json:"points"`
+}
+
+// apiHistoryHandler serves a search's listing count or median price over
+// ?range= (default 30d) from the history database, for charting trends longer
+// than Prometheus keeps. Pick the search like /listings, or with
+// ?key=<search> for one that isn't configured any more. ?step=1d averages the
+// points in each step, and ?format=csv returns a CSV file.
+func (dc domainCollector) apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if dc.history == nil {
+		writeJSONError(w, http.StatusNotFound, "history needs --history.db")
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = "median_price"
+	}
+	value, ok := historyMetrics[metric]
+	if !ok {
+		dc.badRequest(w, badParam(reasonInvalidValue, "metric", metric, "unknown metric %q, want count, median_price or median_rent", metric))
+		return
+	}
+	durations := map[string]time.Duration{"range": 30 * 24 * time.Hour}
+	for _, param := range []string{"range", "step"} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		d, err := model.ParseDuration(v)
+		if err != nil || d <= 0 {
+			dc.badRequest(w, badParam(reasonInvalidValue, param, v, "bad %s %q, want a duration like 90d", param, v))
+			return
+		}
+		durations[param] = time.Duration(d)
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		dc.badRequest(w, badParam(reasonInvalidValue, "format", format, "unknown format %q, want json or csv", format))
+		return
+	}
+	params := []string{"metric", "range", "step", "format"}
+	key := q.Get("key")
+	if key != "" {
+		if err := checkParams(q, append(params, "key")...); err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+	} else {
+		module, rsr, err := dc.searchFromQuery(q, params...)
+		if err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+		key = searchKey(module, rsr)
+	}
+	to := time.Now().UTC()
+	resp := apiHistoryResponse{Search: key, Metric: metric, From: to.Add(-durations["range"]), To: to, Points: []apiHistoryPoint{}}
+	snapshots, err := dc.history.snapshots(r.Context(), key, resp.From, resp.To)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, secrets.redact(fmt.Sprintf("error reading history: %v", err)))
+		return
+	}
+	for _, p := range summarizeSnapshots(snapshots) {
+		if v, ok := value(p); ok {
+			resp.Points = append(resp.Points, apiHistoryPoint{p.Time.UTC(), v})
+		}
+	}
+	if step := durations["step"]; step != 0 {
+		resp.Points = averageHistoryPoints(resp.Points, step)
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", metric+".csv"))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", metric})
+		for _, p := range resp.Points {
+			cw.Write([]string{p.Time.Format(time.RFC3339), strconv.FormatFloat(p.Value, 'f', -1, 64)})
+		}
+		cw.Flush()
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// averageHistoryPoints averages points, which must be sorted by time, in
+// each step, as time.Truncate rounds them, so daily steps are UTC days. Each
+// average is at the start of its step.
+func averageHistoryPoints(points []apiHistoryPoint, step time.Duration) []apiHistoryPoint {
+	averaged := []apiHistoryPoint{}
+	n := 0
+	for _, p := range points {
+		t := p.Time.Truncate(step)
+		if len(averaged) == 0 || !averaged[len(averaged)-1].Time.Equal(t) {
+			if n > 0 {
+				averaged[len(averaged)-1].Value /= float64(n)
+			}
+			averaged = append(averaged, apiHistoryPoint{Time: t})
+			n = 0
+		}
+		averaged[len(averaged)-1].Value += p.Value
+		n++
+	}
+	if n > 0 {
+		averaged[len(averaged)-1].Value /= float64(n)
+	}
+	return averaged
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(w, "error encoding response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
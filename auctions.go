@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// auctionCities are the cities Domain publishes auction results for.
+var auctionCities = []string{"adelaide", "brisbane", "canberra", "melbourne", "sydney"}
+
+// auctionResults is a city's weekend auction results, from Domain's
+// /v1/salesResults/{city}.
+type auctionResults struct {
+	City                 string  `json:"city"`
+	AuctionedDate        string  `json:"auctionedDate"`
+	LastModifiedDateTime string  `json:"lastModifiedDateTime"`
+	ListedForAuction     int     `json:"numberListedForAuction"`
+	Auctioned            int     `json:"numberAuctioned"`
+	Sold                 int     `json:"numberSold"`
+	SoldPriorToAuction   int     `json:"numberSoldPriorToAuction"`
+	SoldAfterAuction     int     `json:"numberSoldAfterAuction"`
+	PassedIn             int     `json:"numberPassedIn"`
+	Withdrawn            int     `json:"numberWithdrawn"`
+	Unreported           int     `json:"numberUnreported"`
+	ClearanceRate        float64 `json:"adjClearanceRate"`
+	Median               float64 `json:"median"`
+	TotalSales           float64 `json:"totalSales"`
+}
+
+// auctionCache remembers each city's auction results until Domain is likely
+// to have published new ones.
+type auctionCache struct {
+	mu     sync.Mutex
+	cities map[string]cachedAuctionResults
+}
+
+type cachedAuctionResults struct {
+	results auctionResults
+	expires time.Time
+}
+
+func newAuctionCache() *auctionCache {
+	return &auctionCache{cities: map[string]cachedAuctionResults{}}
+}
+
+// auctionResultsExpiry returns when results fetched at t should be fetched
+// again. Results for Saturday's auctions come out on Saturday evening and are
+// revised as more are reported until Monday, so they're refreshed hourly then,
+// and kept until the next Saturday evening otherwise.
+func auctionResultsExpiry(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday, time.Sunday, time.Monday:
+		return t.Add(time.Hour)
+	}
+	days := (int(time.Saturday) - int(t.Weekday()) + 7) % 7
+	y, m, d := t.AddDate(0, 0, days).Date()
+	return time.Date(y, m, d, 17, 0, 0, 0, t.Location())
+}
+
+// get returns a city's auction results, from the cache if they're fresh.
+func (c *auctionCache) get(ctx context.Context, dc domainCollector, city string) (auctionResults, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if cached, ok := c.cities[city]; ok && now.Before(cached.expires) {
+		return cached.results, nil
+	}
+	ctx, span := tracer.Start(ctx, "auction results")
+	defer span.End()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.domain.com.au/v1/salesResults/"+city, nil)
+	if err != nil {
+		return auctionResults{}, err
+	}
+	resp, err := dc.httpClient(ctx).Do(req)
+	if err != nil {
+		return auctionResults{}, redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return auctionResults{}, fmt.Errorf("auction results returned %s", resp.Status)
+	}
+	var r auctionResults
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return auctionResults{}, fmt.Errorf("couldn't parse auction results: %v", err)
+	}
+	r.City = city
+	c.cities[city] = cachedAuctionResults{r, auctionResultsExpiry(now)}
+	return r, nil
+}
+
+// auctionResultsHandler serves the latest weekend auction results for
+// ?city=, as JSON, or as metrics with ?format=prometheus.
+func (dc domainCollector) auctionResultsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if err := checkParams(q, "city", "format"); err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	city := strings.ToLower(q.Get("city"))
+	if city == "" {
+		dc.badRequest(w, badParam(reasonMissingParam, "city", "", "pass ?city=, one of %s", strings.Join(auctionCities, ", ")))
+		return
+	}
+	if !slices.Contains(auctionCities, city) {
+		dc.badRequest(w, badParam(reasonInvalidValue, "city", q.Get("city"), "unknown city %q, want one of %s", q.Get("city"), strings.Join(auctionCities, ", ")))
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "prometheus" {
+		dc.badRequest(w, badParam(reasonInvalidValue, "format", format, "unknown format %q, want json or prometheus", format))
+		return
+	}
+	results, err := dc.auctions.get(r.Context(), dc, city)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("error fetching auction results: %v", err))
+		return
+	}
+	if format == "prometheus" {
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(auctionResultsCollector(results))
+		promhttp.HandlerFor(reg, openMetricsOpts).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+var (
+	auctionResultsDesc = prometheus.NewDesc(
+		"domain_auction_results",
+		"Number of properties listed for auction in the latest weekend's results, by result.",
+		[]string{"city", "result"}, nil,
+	)
+	auctionClearanceRateDesc = prometheus.NewDesc(
+		"domain_auction_clearance_rate",
+		"Adjusted clearance rate of the latest weekend's auctions, as reported by Domain.",
+		[]string{"city"}, nil,
+	)
+	auctionMedianPriceDesc = prometheus.NewDesc(
+		"domain_auction_median_price",
+		"Median price of properties sold in the latest weekend's auction results.",
+		[]string{"city"}, nil,
+	)
+)
+
+// auctionResultsCollector exports auction results as metrics.
+type auctionResultsCollector auctionResults
+
+// Describe implements prometheus.Collector.
+func (c auctionResultsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- auctionResultsDesc
+	ch <- auctionClearanceRateDesc
+	ch <- auctionMedianPriceDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c auctionResultsCollector) Collect(ch chan<- prometheus.Metric) {
+	for result, n := range map[string]int{
+		"sold":                  c.Sold,
+		"sold_prior_to_auction": c.SoldPriorToAuction,
+		"sold_after_auction":    c.SoldAfterAuction,
+		"passed_in":             c.PassedIn,
+		"withdrawn":             c.Withdrawn,
+		"unreported":            c.Unreported,
+	} {
+		ch <- prometheus.MustNewConstMetric(auctionResultsDesc, prometheus.GaugeValue, float64(n), c.City, result)
+	}
+	ch <- prometheus.MustNewConstMetric(auctionClearanceRateDesc, prometheus.GaugeValue, c.ClearanceRate, c.City)
+	if c.Median != 0 {
+		ch <- prometheus.MustNewConstMetric(auctionMedianPriceDesc, prometheus.GaugeValue, c.Median, c.City)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Endpoint string    `json:"endpoint"`
+	// Query is the request's query parameters, or its JSON body, with keys
+	// sorted and nulls and empty strings left out, so the same search always
+	// looks the same.
+	Query    string  `json:"query,omitempty"`
+	Status   int     `json:"status,omitempty"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+	// RequestID and Trigger are the inbound request the call was made for,
+	// like "GET /listings", or what else made it, like "push".
+	RequestID string `json:"request_id,omitempty"`
+	Trigger   string `json:"trigger,omitempty"`
+	// Tenant is whose keys the call was made with, if not the default ones.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// auditLog writes a record of every Domain API call, including OAuth token
+// fetches and retries, as JSON lines, for working out where the daily quota
+// went.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// openAuditLog returns an auditLog appending to the file at path, or writing
+// to stdout if path is "-".
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "-" {
+		return &auditLog{w: redactWriter{os.Stdout}}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: redactWriter{f}}, nil
+}
+
+func (a *auditLog) write(r auditRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		slog.Error("error encoding audit record", "err", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		slog.Error("error writing audit log", "err", err)
+	}
+}
+
+// auditTransport records the requests made through it in an auditLog, once
+// their responses have been read.
+type auditTransport struct {
+	base http.RoundTripper
+	log  *auditLog
+}
+
+func (t auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := auditRecord{
+		Time:      time.Now(),
+		Method:    req.Method,
+		Endpoint:  req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		Query:     auditQuery(req),
+		RequestID: requestID(req.Context()),
+		Trigger:   trigger(req.Context()),
+		Tenant:    tenant(req.Context()),
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		rec.Duration = time.Since(rec.Time).Seconds()
+		rec.Error = err.Error()
+		t.log.write(rec)
+		return nil, err
+	}
+	rec.Status = resp.StatusCode
+	resp.Body = &countingReader{ReadCloser: resp.Body, done: func(n int64) {
+		rec.Bytes = n
+		rec.Duration = time.Since(rec.Time).Seconds()
+		t.log.write(rec)
+	}}
+	return resp, nil
+}
+
+// auditQuery returns req's query parameters, sorted, or its JSON body,
+// normalized.
+func auditQuery(req *http.Request) string {
+	if req.URL.RawQuery != "" {
+		return req.URL.Query().Encode()
+	}
+	if req.GetBody == nil {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		// Not JSON, like an OAuth token request, which has the client
+		// secret in it.
+		return ""
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(dropNulls(v)); err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(buf.Bytes()))
+}
+
+// dropNulls removes the null and empty string values from JSON objects in v.
+func dropNulls(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if e == nil || e == "" {
+				delete(v, k)
+			} else {
+				v[k] = dropNulls(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = dropNulls(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// httpAuth protects some endpoints, separately from --web.config.file, which
+// protects them all. Requests need one of the bearer tokens, or the username
+// and password with basic auth. The endpoints that search the Domain API have
+// one, since every unauthenticated request spends API quota, and /metrics
+// another.
+type httpAuth struct {
+	// what is what's protected, for errors, like "searching".
+	what               string
+	tokens             []string
+	username, password string
+}
+
+// newHTTPAuth returns an httpAuth, or nil if no tokens or password are set.
+func newHTTPAuth(what string, tokens []string, username, password string) *httpAuth {
+	a := &httpAuth{what: what, username: username, password: password}
+	for _, t := range tokens {
+		if t = strings.TrimSpace(t); t != "" {
+			a.tokens = append(a.tokens, t)
+		}
+	}
+	if len(a.tokens) == 0 && a.password == "" {
+		return nil
+	}
+	return a
+}
+
+// httpAuthFromFlags returns the httpAuth for a set of flags, with tokens and
+// password defaulting to $<env>_TOKENS and $<env>_PASSWORD.
+func httpAuthFromFlags(what string, tokens, username, password *string, env string) *httpAuth {
+	if *tokens == "" {
+		*tokens = os.Getenv(env + "_TOKENS")
+	}
+	if *password == "" {
+		*password = os.Getenv(env + "_PASSWORD")
+	}
+	a := newHTTPAuth(what, strings.Split(*tokens, ","), *username, *password)
+	if a != nil {
+		secrets.add(a.tokens...)
+		secrets.add(a.password)
+	}
+	return a
+}
+
+// check reports whether an Authorization header value is allowed.
+func (a *httpAuth) check(authorization string) bool {
+	if a == nil {
+		return true
+	}
+	scheme, cred, _ := strings.Cut(authorization, " ")
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		ok := false
+		for _, t := range a.tokens {
+			// Check every token, so the time taken doesn't say which matched.
+			if subtle.ConstantTimeCompare([]byte(cred), []byte(t)) == 1 {
+				ok = true
+			}
+		}
+		return ok
+	case "basic":
+		if a.password == "" {
+			return false
+		}
+		b, err := base64.StdEncoding.DecodeString(cred)
+		if err != nil {
+			return false
+		}
+		user, pass, _ := strings.Cut(string(b), ":")
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.password)) == 1
+		return userOK && passOK
+	}
+	return false
+}
+
+// allow reports whether r is allowed, responding 401 if it isn't.
+func (a *httpAuth) allow(w http.ResponseWriter, r *http.Request) bool {
+	if a.check(r.Header.Get("Authorization")) {
+		return true
+	}
+	if a.password != "" {
+		w.Header().Add("WWW-Authenticate", `Basic realm="domain_exporter"`)
+	}
+	if len(a.tokens) > 0 {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="domain_exporter"`)
+	}
+	http.Error(w, a.what+" needs a bearer token or basic auth", http.StatusUnauthorized)
+	return false
+}
+
+// wrap returns h, only letting through allowed requests.
+func (a *httpAuth) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.allow(w, r) {
+			h(w, r)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// backup writes every snapshot in the history database to w, as gzipped JSON
+// lines of listingSnapshot, oldest first. The format doesn't depend on the
+// database, so backups of SQLite can be restored to Postgres and back.
+func (h *historyStore) backup(ctx context.Context, w io.Writer) (int, error) {
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM listing_snapshots ORDER BY observed_at, search, listing_id", snapshotColumns))
+	if err != nil {
+		return 0, err
+	}
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	n := 0
+	err = scanSnapshots(rows, func(s listingSnapshot) error {
+		n++
+		return enc.Encode(s)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, zw.Close()
+}
+
+// restore reads a backup from r into the history database, skipping
+// searches' results that are already in it, so restoring the same backup
+// twice, or one that overlaps what's been recorded since, doesn't duplicate
+// them.
+func (h *historyStore) restore(ctx context.Context, r io.Reader) (restored, skipped int, err error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("not a backup: %v", err)
+	}
+	dec := json.NewDecoder(zr)
+	m := h.merger()
+	for {
+		var s listingSnapshot
+		if err := dec.Decode(&s); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return m.merged, m.skipped, fmt.Errorf("bad backup: %v", err)
+		}
+		if err := m.add(ctx, s); err != nil {
+			return m.merged, m.skipped, err
+		}
+	}
+	err = m.flush(ctx)
+	return m.merged, m.skipped, err
+}
+
+// snapshotMerger adds snapshots to the history database a search's results
+// at a time, skipping results from times already in it. Snapshots must be
+// added in order of time and search.
+type snapshotMerger struct {
+	h      *historyStore
+	exists string
+
+	batch    []listingSummary
+	search   string
+	observed time.Time
+
+	merged, skipped int
+}
+
+func (h *historyStore) merger() *snapshotMerger {
+	return &snapshotMerger{
+		h:      h,
+		exists: fmt.Sprintf("SELECT COUNT(*) FROM listing_snapshots WHERE search = %s AND observed_at = %s", h.placeholder(1), h.placeholder(2)),
+	}
+}
+
+func (m *snapshotMerger) add(ctx context.Context, s listingSnapshot) error {
+	if s.Search != m.search || !s.ObservedAt.Equal(m.observed) {
+		if err := m.flush(ctx); err != nil {
+			return err
+		}
+		m.search, m.observed = s.Search, s.ObservedAt
+	}
+	m.batch = append(m.batch, s.listingSummary)
+	return nil
+}
+
+// flush writes the current search's results, if they're new.
+func (m *snapshotMerger) flush(ctx context.Context) error {
+	if len(m.batch) == 0 {
+		return nil
+	}
+	var n int
+	if err := m.h.db.QueryRowContext(ctx, m.exists, m.search, m.observed.UTC()).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		m.skipped += len(m.batch)
+	} else {
+		if err := m.h.insert(ctx, m.search, m.observed, m.batch); err != nil {
+			return err
+		}
+		m.merged += len(m.batch)
+	}
+	m.batch = m.batch[:0]
+	return nil
+}
+
+// adminBackupHandler serves a backup of the history database.
+func (dc domainCollector) adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if dc.history == nil {
+		http.Error(w, "backups need --history.db", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "domain_exporter-"+time.Now().UTC().Format("20060102-150405")+".jsonl.gz"))
+	n, err := dc.history.backup(r.Context(), w)
+	if err != nil {
+		// It's too late to change the status, but the gzip stream is
+		// unterminated, so the backup won't restore.
+		slog.Error("error backing up history", "snapshots", n, "err", secrets.redact(err.Error()))
+		return
+	}
+	slog.Info("Backed up history", "snapshots", n)
+}
+
+// adminRestoreHandler restores a backup POSTed to it into the history
+// database.
+func (dc domainCollector) adminRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST a backup to restore it")
+		return
+	}
+	if dc.history == nil {
+		writeJSONError(w, http.StatusNotFound, "restoring needs --history.db")
+		return
+	}
+	restored, skipped, err := dc.history.restore(r.Context(), r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, secrets.redact(fmt.Sprintf("error restoring after %d snapshots: %v", restored, err)))
+		return
+	}
+	slog.Info("Restored history", "restored", restored, "skipped", skipped)
+	writeJSON(w, http.StatusOK, map[string]int{"restored": restored, "skipped": skipped})
+}
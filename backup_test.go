@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testHistory(t *testing.T) *historyStore {
+	t.Helper()
+	h, err := openHistory("sqlite://" + filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.db.Close() })
+	return h
+}
+
+func countSnapshots(t *testing.T, h *historyStore) int {
+	t.Helper()
+	var n int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM listing_snapshots").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSnapshotMerger(t *testing.T) {
+	t1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	snap := func(search string, observed time.Time, id int32) listingSnapshot {
+		s := listingSnapshot{ObservedAt: observed, Search: search}
+		s.ID = id
+		return s
+	}
+	for _, tc := range []struct {
+		name string
+		// existing are the results already in the database.
+		existing                           []listingSnapshot
+		add                                []listingSnapshot
+		wantMerged, wantSkipped, wantTotal int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:       "into an empty database",
+			add:        []listingSnapshot{snap("a", t1, 1), snap("a", t1, 2), snap("a", t2, 1), snap("b", t2, 3)},
+			wantMerged: 4, wantTotal: 4,
+		},
+		{
+			name:        "all already there",
+			existing:    []listingSnapshot{snap("a", t1, 1), snap("a", t1, 2)},
+			add:         []listingSnapshot{snap("a", t1, 1), snap("a", t1, 2)},
+			wantSkipped: 2, wantTotal: 2,
+		},
+		{
+			// Results are skipped a whole search at a time, even if they
+			// differ from what's there.
+			name:        "some of a search already there",
+			existing:    []listingSnapshot{snap("a", t1, 1)},
+			add:         []listingSnapshot{snap("a", t1, 1), snap("a", t1, 2)},
+			wantSkipped: 2, wantTotal: 1,
+		},
+		{
+			name:       "overlapping",
+			existing:   []listingSnapshot{snap("a", t1, 1)},
+			add:        []listingSnapshot{snap("a", t1, 1), snap("a", t2, 1), snap("a", t2, 2)},
+			wantMerged: 2, wantSkipped: 1, wantTotal: 3,
+		},
+		{
+			name:       "same time, other search",
+			existing:   []listingSnapshot{snap("a", t1, 1)},
+			add:        []listingSnapshot{snap("b", t1, 1)},
+			wantMerged: 1, wantTotal: 2,
+		},
+		{
+			name:       "same time in another zone",
+			existing:   []listingSnapshot{snap("a", t1, 1)},
+			add:        []listingSnapshot{snap("a", t1.In(time.FixedZone("AEST", 10*60*60)), 1), snap("a", t2, 1)},
+			wantMerged: 1, wantSkipped: 1, wantTotal: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			h := testHistory(t)
+			m := h.merger()
+			for _, s := range tc.existing {
+				if err := m.add(ctx, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			m = h.merger()
+			for _, s := range tc.add {
+				if err := m.add(ctx, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if m.merged != tc.wantMerged || m.skipped != tc.wantSkipped {
+				t.Errorf("merged %d and skipped %d, want %d and %d", m.merged, m.skipped, tc.wantMerged, tc.wantSkipped)
+			}
+			if n := countSnapshots(t, h); n != tc.wantTotal {
+				t.Errorf("%d snapshots in the database, want %d", n, tc.wantTotal)
+			}
+		})
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	from := testHistory(t)
+	observed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	listings := []listingSummary{
+		{ID: 1, Address: "1 Harris St", Suburb: "Pyrmont", State: "NSW", Postcode: "2009", Bedrooms: 2, Price: 1e6},
+		{ID: 2, Address: "2 Harris St", Suburb: "Pyrmont", State: "NSW", Postcode: "2009", Bedrooms: 3},
+	}
+	if err := from.record(ctx, "module:pyrmont", observed, listings); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if n, err := from.backup(ctx, &buf); err != nil || n != 2 {
+		t.Fatalf("backup() = %d, %v, want 2 snapshots", n, err)
+	}
+	backup := buf.Bytes()
+
+	to := testHistory(t)
+	if restored, skipped, err := to.restore(ctx, bytes.NewReader(backup)); err != nil || restored != 2 || skipped != 0 {
+		t.Fatalf("restore() = %d, %d, %v, want 2 restored", restored, skipped, err)
+	}
+	if restored, skipped, err := to.restore(ctx, bytes.NewReader(backup)); err != nil || restored != 0 || skipped != 2 {
+		t.Errorf("restoring again = %d, %d, %v, want 2 skipped", restored, skipped, err)
+	}
+	got, err := to.snapshots(ctx, "module:pyrmont", observed, observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].listingSummary != listings[0] || got[1].listingSummary != listings[1] {
+		t.Errorf("restored %+v, want %+v", got, listings)
+	}
+
+	if _, _, err := to.restore(ctx, bytes.NewReader([]byte("not gzip"))); err == nil {
+		t.Errorf("restoring something that isn't a backup succeeded")
+	}
+}
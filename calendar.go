@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// auctionDuration is how long auctions are shown for in the calendar, as
+// Domain only gives a start time, and inspections without a closing time.
+const auctionDuration = 30 * time.Minute
+
+// icalEscaper escapes TEXT values, per RFC 5545 section 3.3.11.
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalLineLength is the longest a content line can be, in octets, before it's
+// folded, per RFC 5545 section 3.1.
+const icalLineLength = 75
+
+// icalFold folds a content line onto as many lines as it needs, each but the
+// first starting with a space, without splitting UTF-8 characters.
+func icalFold(line string) string {
+	var b strings.Builder
+	limit := icalLineLength
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		b.WriteString(line[:i])
+		b.WriteString("\r\n ")
+		line = line[i:]
+		// The space counts.
+		limit = icalLineLength - 1
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// inspectionTime is when a property is open for inspection.
+type inspectionTime struct {
+	OpeningTime string `json:"openingTime"`
+	ClosingTime string `json:"closingTime"`
+}
+
+// inspections collects the inspection times of listings from Domain API
+// responses, which the domain package's structs leave out.
+type inspections struct {
+	mu    sync.Mutex
+	times map[int32][]inspectionTime
+}
+
+type inspectionsKey struct{}
+
+// withInspections returns a context whose searches collect inspection times
+// into the returned inspections.
+func withInspections(ctx context.Context) (context.Context, *inspections) {
+	in := &inspections{times: map[int32][]inspectionTime{}}
+	return context.WithValue(ctx, inspectionsKey{}, in), in
+}
+
+// inspectionsFrom returns the inspections to collect into for ctx, or nil.
+func inspectionsFrom(ctx context.Context) *inspections {
+	in, _ := ctx.Value(inspectionsKey{}).(*inspections)
+	return in
+}
+
+// collect reads the inspection times from a search response, leaving its body
+// to be read again.
+func (in *inspections) collect(resp *http.Response) {
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	var page []struct {
+		Listing struct {
+			ID                 int32 `json:"id"`
+			InspectionSchedule struct {
+				Times []inspectionTime `json:"times"`
+			} `json:"inspectionSchedule"`
+		} `json:"listing"`
+	}
+	if json.Unmarshal(b, &page) != nil {
+		// The domain client will say what's wrong.
+		return
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, r := range page {
+		if t := r.Listing.InspectionSchedule.Times; len(t) > 0 {
+			in.times[r.Listing.ID] = t
+		}
+	}
+}
+
+func (in *inspections) get(id int32) []inspectionTime {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.times[id]
+}
+
+// calendarHandler serves an iCalendar feed of the auctions and inspections of
+// the listings found by a search, for subscribing to from a calendar app.
+func (dc domainCollector) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	module, rsr, err := dc.searchFromQuery(r.URL.Query())
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	ctx, in := withInspections(r.Context())
+	listings, err := dc.search(ctx, module, rsr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error searching domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger := searchLogger(r.Context(), module, rsr)
+	now := time.Now().UTC().Format("20060102T150405Z")
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		b.WriteString(icalFold(fmt.Sprintf(format, args...)))
+		b.WriteString("\r\n")
+	}
+	// Auction and inspection times are local to the property, without a time
+	// zone, so they're written as floating times.
+	parse := func(id int32, what, t string) (time.Time, bool) {
+		start, err := time.Parse("2006-01-02T15:04:05", t)
+		if err != nil {
+			logger.Warn("couldn't parse "+what+" time", "id", id, "time", t, "err", err)
+			return start, false
+		}
+		return start, true
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//mhansen//domain_exporter//EN")
+	line("X-WR-CALNAME:%s", icalEscaper.Replace(strings.TrimSpace("Auctions and inspections "+module)))
+	for _, l := range listings {
+		s := summarize(l)
+		for _, it := range in.get(s.ID) {
+			start, ok := parse(s.ID, "inspection", it.OpeningTime)
+			if !ok {
+				continue
+			}
+			end, ok := parse(s.ID, "inspection", it.ClosingTime)
+			if !ok || !end.After(start) {
+				end = start.Add(auctionDuration)
+			}
+			line("BEGIN:VEVENT")
+			line("UID:inspection-%d-%s@domain-exporter", s.ID, start.Format("20060102T1504"))
+			line("DTSTAMP:%s", now)
+			line("DTSTART:%s", start.Format("20060102T150405"))
+			line("DTEND:%s", end.Format("20060102T150405"))
+			line("SUMMARY:%s", icalEscaper.Replace("Inspection: "+s.Address))
+			line("LOCATION:%s", icalEscaper.Replace(s.Address))
+			line("DESCRIPTION:%s", icalEscaper.Replace(s.Headline+"\n"+s.DisplayPrice))
+			line("URL:%s", s.URL)
+			line("END:VEVENT")
+		}
+		a := l.Listing.AuctionSchedule
+		if a.Time == "" {
+			continue
+		}
+		start, ok := parse(s.ID, "auction", a.Time)
+		if !ok {
+			continue
+		}
+		location := a.AuctionLocation
+		if location == "" {
+			location = s.Address
+		}
+		line("BEGIN:VEVENT")
+		line("UID:auction-%d@domain-exporter", s.ID)
+		line("DTSTAMP:%s", now)
+		line("DTSTART:%s", start.Format("20060102T150405"))
+		line("DTEND:%s", start.Add(auctionDuration).Format("20060102T150405"))
+		line("SUMMARY:%s", icalEscaper.Replace("Auction: "+s.Address))
+		line("LOCATION:%s", icalEscaper.Replace(location))
+		line("DESCRIPTION:%s", icalEscaper.Replace(s.Headline+"\n"+s.DisplayPrice))
+		line("URL:%s", s.URL)
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// canaryFamilies are the metric families every running exporter has, which
+// the canary command checks for.
+var canaryFamilies = []string{
+	"domain_exporter_build_info",
+	"domain_api_info",
+	"domain_exporter_config_info",
+	"domain_api_success_ratio_1h",
+}
+
+// runCanary checks a running exporter from outside, for watchdogs run from
+// cron: that it's ready, that its /metrics has the families it should, and
+// that its searches are fresh. Only the exporter's own freshness metrics are
+// looked at, so it costs no Domain API calls. It returns an error listing
+// everything that's wrong.
+func runCanary(ctx context.Context, base, token string, families []string, maxAge, timeout time.Duration) error {
+	if base == "" {
+		return fmt.Errorf("--url is required")
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil {
+		return fmt.Errorf("bad --url: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	get := func(path string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String()+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("User-Agent", "domain-exporter-canary/"+version)
+		return http.DefaultClient.Do(req)
+	}
+
+	var problems []string
+	resp, err := get("/readyz")
+	if err != nil {
+		return fmt.Errorf("couldn't reach the exporter: %v", err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		problems = append(problems, fmt.Sprintf("/readyz: %s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+
+	resp, err = get("/metrics")
+	if err != nil {
+		return fmt.Errorf("couldn't scrape the exporter: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/metrics: %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't parse /metrics: %v", err)
+	}
+	for _, f := range families {
+		if _, ok := mfs[f]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing from /metrics", f))
+		}
+	}
+	if mf, ok := mfs["domain_search_fresh"]; ok && maxAge == 0 {
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 0 {
+				problems = append(problems, fmt.Sprintf("module %s is stale, by the exporter's --slo.freshness", metricLabel(m, "module")))
+			}
+		}
+	}
+	if maxAge > 0 {
+		// Instead of the exporter's own --slo.freshness.
+		problems = append(problems, canaryAges(mfs, maxAge)...)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems: %s", len(problems), strings.Join(problems, "; "))
+	}
+	fmt.Printf("OK: %s is ready, with %d metric families\n", u.Redacted(), len(mfs))
+	return nil
+}
+
+// canaryAges returns a problem for each module whose search hasn't
+// succeeded within maxAge, going by domain_search_last_success_timestamp_seconds.
+// Every module the exporter has is in domain_search_fresh, so modules that
+// have never succeeded are found there.
+func canaryAges(mfs map[string]*dto.MetricFamily, maxAge time.Duration) []string {
+	fresh, ok := mfs["domain_search_fresh"]
+	if !ok {
+		return []string{"domain_search_fresh is missing from /metrics, run the exporter with --slo.freshness to check how fresh its searches are"}
+	}
+	last := map[string]time.Time{}
+	for _, m := range mfs["domain_search_last_success_timestamp_seconds"].GetMetric() {
+		v := m.GetGauge().GetValue()
+		last[metricLabel(m, "module")] = time.Unix(0, int64(v*1e9))
+	}
+	var problems []string
+	for _, m := range fresh.GetMetric() {
+		module := metricLabel(m, "module")
+		t, ok := last[module]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("module %s hasn't succeeded since the exporter started", module))
+		case time.Since(t) > maxAge:
+			problems = append(problems, fmt.Sprintf("module %s last succeeded %v ago, more than --canary.max-age", module, time.Since(t).Round(time.Second)))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// metricLabel returns the value of a metric's label, or "" if it hasn't got it.
+func metricLabel(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
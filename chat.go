@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// defaultChatTemplate formats events for chat messages. It is executed with
+// the []listingEvent being sent.
+const defaultChatTemplate = `{{range .}}{{if eq .Type "alert"}}Alert: {{.Alert}}{{if .Listing.URL}} {{.Listing.URL}}{{end}}
+{{else}}{{if eq .Type "new"}}New{{else if eq .Type "price_drop"}}Price drop{{else if eq .Type "price_rise"}}Price rise{{else}}Gone{{end}}: {{.Listing.Address}}, {{.Listing.Bedrooms}} bed, {{.Listing.DisplayPrice}}{{if .PreviousPrice}} (was ${{.PreviousPrice}}){{end}} {{.Listing.URL}}
+{{end}}{{end}}`
+
+// discordMaxLength is the longest message Discord accepts.
+const discordMaxLength = 2000
+
+// loadChatTemplate parses the template in path, or the default template if
+// path is empty.
+func loadChatTemplate(path string) (*template.Template, error) {
+	text := defaultChatTemplate
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	return template.New("chat").Parse(text)
+}
+
+// chatEncoder returns a webhookNotifier encode function that renders events
+// with tmpl and passes the message to wrap to build the request body.
+func chatEncoder(tmpl *template.Template, wrap func(msg string) interface{}) func([]listingEvent) ([]byte, error) {
+	return func(events []listingEvent) ([]byte, error) {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, events); err != nil {
+			return nil, fmt.Errorf("couldn't render message: %v", err)
+		}
+		return json.Marshal(wrap(strings.TrimSpace(b.String())))
+	}
+}
+
+// newSlackNotifier posts to a Slack incoming webhook.
+func newSlackNotifier(url string, tmpl *template.Template) *webhookNotifier {
+	n := newWebhookNotifier(url)
+	n.encode = chatEncoder(tmpl, func(msg string) interface{} {
+		return map[string]string{"text": msg}
+	})
+	return n
+}
+
+// newDiscordNotifier posts to a Discord webhook. Messages that are too long
+// for Discord are cut short.
+func newDiscordNotifier(url string, tmpl *template.Template) *webhookNotifier {
+	n := newWebhookNotifier(url)
+	n.encode = chatEncoder(tmpl, func(msg string) interface{} {
+		if r := []rune(msg); len(r) > discordMaxLength {
+			msg = string(r[:discordMaxLength-1]) + "…"
+		}
+		return map[string]string{"content": msg}
+	})
+	return n
+}
+
+// newTelegramNotifier sends messages to a Telegram chat from a bot.
+func newTelegramNotifier(token, chatID string, tmpl *template.Template) *webhookNotifier {
+	n := newWebhookNotifier("https://api.telegram.org/bot" + token + "/sendMessage")
+	n.encode = chatEncoder(tmpl, func(msg string) interface{} {
+		return map[string]interface{}{"chat_id": chatID, "text": msg, "disable_web_page_preview": true}
+	})
+	return n
+}
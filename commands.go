@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/common/expfmt"
+)
+
+// commands are the things the exporter can do, given as its first argument.
+// Without one, it serves.
+var commands = map[string]string{
+	"serve":   "Serve metrics over HTTP (the default)",
+	"query":   "Run a module's search and print its metrics to stdout",
+	"check":   "Check the flags and searches, make a minimal Domain API call, and exit",
+	"export":  "Run a module's search and print its listings to stdout, as CSV or JSON",
+	"backup":  "Write a backup of --history.db to stdout",
+	"restore": "Restore a backup from stdin into --history.db",
+	"import":  "Merge listings exported as CSV, JSON or Parquet, given as arguments, into --history.db",
+	"canary":  "Check that the exporter at --url is ready, with the metrics it should have, and fresh searches",
+}
+
+// commandAliases are other names for commands.
+var commandAliases = map[string]string{
+	"once": "query",
+}
+
+// parseCommand removes the command from the start of os.Args, and returns it.
+func parseCommand() (string, error) {
+	if len(os.Args) < 2 || len(os.Args[1]) == 0 || os.Args[1][0] == '-' {
+		return "serve", nil
+	}
+	command := os.Args[1]
+	os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	if c, ok := commandAliases[command]; ok {
+		command = c
+	}
+	if _, ok := commands[command]; !ok {
+		return "", fmt.Errorf("unknown command %q", command)
+	}
+	return command, nil
+}
+
+// usage prints the commands and flags.
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range []string{"serve", "query", "check", "export", "backup", "restore", "import", "canary"} {
+		fmt.Fprintf(w, "  %-8s %s\n", c, commands[c])
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// moduleSearch returns the search for the --module flag.
+func (dc domainCollector) moduleSearch(module string) (domain.ResidentialSearchRequest, error) {
+	if module == "" {
+		return domain.ResidentialSearchRequest{}, fmt.Errorf("--module is required")
+	}
+	rsr, ok := dc.searches.get(module)
+	if !ok {
+		return rsr, fmt.Errorf("unknown module %q", module)
+	}
+	return rsr, nil
+}
+
+// runQuery runs a module's search, and prints its metrics to stdout in the
+// Prometheus text format.
+func runQuery(ctx context.Context, dc domainCollector, module string) error {
+	rsr, err := dc.moduleSearch(module)
+	if err != nil {
+		return err
+	}
+	reg, err := dc.listingsRegistry(ctx, module, rsr)
+	if err != nil {
+		return err
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCheck checks that the Domain API can be searched. The flags and
+// searches have already been checked by the time it runs.
+func runCheck(ctx context.Context, dc domainCollector) error {
+	if err := dc.checkAPI(ctx); err != nil {
+		return fmt.Errorf("Domain API check failed, is the API key valid and within its daily quota? %v", err)
+	}
+	fmt.Printf("OK: %d searches, Domain API check succeeded\n", len(dc.searches.names()))
+	return nil
+}
+
+// runExport runs a module's search, and prints its listings to stdout.
+func runExport(ctx context.Context, dc domainCollector, module, format string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown --format %q, want csv or json", format)
+	}
+	rsr, err := dc.moduleSearch(module)
+	if err != nil {
+		return err
+	}
+	listings, err := dc.search(ctx, module, rsr)
+	if err != nil {
+		return err
+	}
+	if format == "csv" {
+		return writeCSV(os.Stdout, listings)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newAPIListingsResponse(module, listings, true))
+}
+
+// runBackup writes a backup of the history database to stdout.
+func runBackup(ctx context.Context, h *historyStore) error {
+	n, err := h.backup(ctx, os.Stdout)
+	if err != nil {
+		return err
+	}
+	slog.Info("Backed up history", "snapshots", n)
+	return nil
+}
+
+// runRestore restores a backup from stdin into the history database.
+func runRestore(ctx context.Context, h *historyStore) error {
+	restored, skipped, err := h.restore(ctx, os.Stdin)
+	if err != nil {
+		return fmt.Errorf("after restoring %d snapshots: %v", restored, err)
+	}
+	slog.Info("Restored history", "restored", restored, "skipped", skipped)
+	return nil
+}
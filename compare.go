@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+)
+
+// maxCompareSuburbs limits how many searches one /compare request runs, to
+// protect the API quota.
+const maxCompareSuburbs = 10
+
+// compareSuburb is one suburb's column in a /compare response.
+type compareSuburb struct {
+	Suburb      string  `json:"suburb"`
+	Count       int     `json:"count"`
+	MedianPrice float64 `json:"medianPrice,omitempty"`
+	// MedianDaysListed is how long the median listing has been listed, an
+	// estimate of days on market.
+	MedianDaysListed float64 `json:"medianDaysListed,omitempty"`
+	Error            string  `json:"error,omitempty"`
+}
+
+var compareTemplate = template.Must(template.New("compare").Parse(`<!doctype html>
+<title>Compare suburbs</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: right; }
+th:first-child { text-align: left; }
+</style>
+<h1>Compare suburbs</h1>
+<table>
+<tr><th></th>{{range .}}<th>{{.Suburb}}</th>{{end}}</tr>
+<tr><th>Listings</th>{{range .}}<td>{{if .Error}}{{.Error}}{{else}}{{.Count}}{{end}}</td>{{end}}</tr>
+<tr><th>Median price</th>{{range .}}<td>{{if .MedianPrice}}{{printf "%.0f" .MedianPrice}}{{end}}</td>{{end}}</tr>
+<tr><th>Median days listed</th>{{range .}}<td>{{if .MedianDaysListed}}{{printf "%.0f" .MedianDaysListed}}{{end}}</td>{{end}}</tr>
+</table>
+`))
+
+// compareHandler runs the same search in each of ?suburbs=, a comma separated
+// list, and returns their count, median price and median days listed side by
+// side, as JSON or with ?format=html as a table. The other parameters, like
+// ?state= and ?listingType=, are the same as /listings.
+func (dc domainCollector) compareHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if err := checkParams(q, "suburbs", "format", "state", "postCode", "includeSurroundingSuburbs", "listingType", "minBedrooms", "maxBedrooms"); err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	var suburbs []string
+	for _, s := range strings.Split(q.Get("suburbs"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			suburbs = append(suburbs, s)
+		}
+	}
+	if len(suburbs) == 0 || len(suburbs) > maxCompareSuburbs {
+		dc.badRequest(w, badParam(reasonInvalidValue, "suburbs", q.Get("suburbs"), "pass between 1 and %d comma separated ?suburbs=", maxCompareSuburbs))
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "html" {
+		dc.badRequest(w, badParam(reasonInvalidValue, "format", format, "unknown format %q, want json or html", format))
+		return
+	}
+	// Every search is checked before any run, so a request that's over a
+	// limit doesn't spend quota on the first few.
+	rsrs := make([]domain.ResidentialSearchRequest, 0, len(suburbs))
+	for _, suburb := range suburbs {
+		sq := url.Values{}
+		for k, v := range q {
+			if k != "suburbs" && k != "format" {
+				sq[k] = v
+			}
+		}
+		sq.Set("suburb", suburb)
+		_, rsr, err := dc.searchFromQuery(sq)
+		if err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+		rsrs = append(rsrs, rsr)
+	}
+	if err := dc.checkTotalCost(rsrs); err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	if dc.limits != nil && len(suburbs) > dc.limits.burst {
+		dc.badRequest(w, badParam(reasonInvalidValue, "suburbs", q.Get("suburbs"), "compare at most %d suburbs at once, the --web.search-rate-burst", dc.limits.burst))
+		return
+	}
+	// The rate limit has already taken one search for the request.
+	if !dc.limits.allowN(w, r, len(suburbs)-1) {
+		return
+	}
+	now := time.Now()
+	results := make([]compareSuburb, 0, len(suburbs))
+	for i, suburb := range suburbs {
+		res := compareSuburb{Suburb: suburb}
+		listings, err := dc.search(r.Context(), "", rsrs[i])
+		if err != nil {
+			res.Error = fmt.Sprintf("error searching domain: %v", err)
+			results = append(results, res)
+			continue
+		}
+		var prices, days []float64
+		for _, l := range listings {
+			if p := collector.ParsePrice(l.Listing.PriceDetails); p != 0 {
+				prices = append(prices, p)
+			}
+			// Domain's dates are Australian local time, without a zone,
+			// which is close enough for counting days.
+			if t, err := time.ParseInLocation("2006-01-02T15:04:05", l.Listing.DateListed, time.Local); err == nil {
+				days = append(days, now.Sub(t).Hours()/24)
+			}
+		}
+		res.Count = len(listings)
+		res.MedianPrice = collector.Median(prices)
+		res.MedianDaysListed = math.Round(collector.Median(days)*10) / 10
+		results = append(results, res)
+	}
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := compareTemplate.Execute(w, results); err != nil {
+			slog.Error("couldn't render comparison", "err", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"suburbs": results})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var configInfoDesc = prometheus.NewDesc(
+	"domain_exporter_config_info",
+	"A hash of the exporter's flags and searches, the number of searches, whether it pushes or is only scraped, and how long search results are cached, to detect config drift between replicas.",
+	[]string{"config_hash", "modules", "mode", "cache_ttl"}, nil)
+
+// secretFlags are the names of the flags defined with secret.
+var secretFlags = map[string]bool{}
+
+// secret marks the flag called name as holding a secret, like an API key or a
+// URL with a password in it. Secrets aren't configuration, and are expected to
+// differ between replicas using different API keys, so configHash leaves them
+// out.
+func secret(name string) string {
+	secretFlags[name] = true
+	return name
+}
+
+// configInfoCollector exports domain_exporter_config_info. It's worked out on
+// each scrape, so it follows changes to the searches.
+type configInfoCollector struct {
+	searches *searchSet
+	// mode is push if the exporter pushes on an interval, or scrape.
+	mode string
+}
+
+// Describe implements prometheus.Collector.
+func (c configInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- configInfoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c configInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	searches := c.searches.all()
+	// Listings aren't cached: every scrape and push searches afresh.
+	ch <- prometheus.MustNewConstMetric(configInfoDesc, prometheus.GaugeValue, 1,
+		configHash(searches), strconv.Itoa(len(searches)), c.mode, "0s")
+}
+
+// configHash hashes the values of all flags but secrets, and the searches.
+func configHash(searches map[string]domain.ResidentialSearchRequest) string {
+	h := sha256.New()
+	flag.VisitAll(func(f *flag.Flag) {
+		if !secretFlags[f.Name] {
+			fmt.Fprintf(h, "%s=%s\n", f.Name, f.Value)
+		}
+	})
+	names := make([]string, 0, len(searches))
+	for name := range searches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, _ := json.Marshal(searches[name])
+		fmt.Fprintf(h, "module %s=%s\n", name, b)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConfigInfo(t *testing.T) {
+	searches := &searchSet{searches: map[string]domain.ResidentialSearchRequest{}}
+	c := configInfoCollector{searches: searches, mode: "scrape"}
+	hash := func() string { return configHash(searches.all()) }
+
+	before := hash()
+	if err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP domain_exporter_config_info A hash of the exporter's flags and searches, the number of searches, whether it pushes or is only scraped, and how long search results are cached, to detect config drift between replicas.
+# TYPE domain_exporter_config_info gauge
+domain_exporter_config_info{cache_ttl="0s",config_hash="`+before+`",mode="scrape",modules="0"} 1
+`)); err != nil {
+		t.Error(err)
+	}
+
+	searches.searches["pyrmont"] = domain.ResidentialSearchRequest{Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont"}}}
+	withSearch := hash()
+	if withSearch == before {
+		t.Errorf("adding a search didn't change the hash")
+	}
+	searches.searches["pyrmont"] = domain.ResidentialSearchRequest{Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Ultimo"}}}
+	if hash() == withSearch {
+		t.Errorf("changing a search didn't change the hash")
+	}
+
+	setFlag := func(name, value string) {
+		t.Helper()
+		old := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { flag.Set(name, old) })
+	}
+	h := hash()
+	setFlag("api_key", "another-key")
+	if hash() != h {
+		t.Errorf("changing a secret flag changed the hash")
+	}
+	setFlag("user_agent", "another-agent")
+	if hash() == h {
+		t.Errorf("changing --user_agent didn't change the hash")
+	}
+}
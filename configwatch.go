@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// configWatcher reloads the searches and alert rules when their files change,
+// like sending SIGHUP, so configs mounted from Kubernetes ConfigMaps stay in
+// sync without a reloader sidecar. It polls rather than waiting for inotify
+// events, as keyFile does: ConfigMap updates swap a symlink to a new
+// directory, which stat follows but watches on the old files miss.
+type configWatcher struct {
+	dc       domainCollector
+	interval time.Duration
+	reloads  *prometheus.CounterVec
+}
+
+func newConfigWatcher(dc domainCollector, interval time.Duration) *configWatcher {
+	return &configWatcher{
+		dc:       dc,
+		interval: interval,
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_config_watch_reloads_total",
+			Help: "Reloads of the searches and alert rules because their files changed, by result.",
+		}, []string{"result"}),
+	}
+}
+
+// run polls until ctx is done.
+func (w *configWatcher) run(ctx context.Context) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	last := w.fingerprint()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		fp := w.fingerprint()
+		if fp == last {
+			continue
+		}
+		// Only try each version of the files once, so a bad one is logged
+		// once rather than every poll until it's fixed.
+		last = fp
+		slog.Info("Config files changed, reloading")
+		if err := w.dc.reload(); err != nil {
+			w.reloads.WithLabelValues("failure").Inc()
+			slog.Error("error reloading changed config, keeping the old one", "err", err)
+			continue
+		}
+		w.reloads.WithLabelValues("success").Inc()
+	}
+}
+
+// fingerprint returns the names, sizes and modification times of the config
+// files, which change when they do.
+func (w *configWatcher) fingerprint() string {
+	files, err := filepath.Glob(filepath.Join(w.dc.searches.dir, "*.json"))
+	if err != nil {
+		return err.Error()
+	}
+	if w.dc.alerts != nil {
+		files = append(files, w.dc.alerts.path)
+	}
+	var b strings.Builder
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			// Probably mid-update; it'll have settled by the next poll.
+			fmt.Fprintf(&b, "%s: %v\n", f, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String()
+}
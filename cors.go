@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsPolicy adds CORS headers to responses, so dashboards served from other
+// origins can call the JSON API, GraphQL and debug pages from the browser.
+type corsPolicy struct {
+	origins map[string]bool
+	any     bool
+	methods string
+}
+
+// newCORSPolicy returns a corsPolicy allowing requests from the comma
+// separated origins, or any origin if they include "*", with methods. It
+// returns nil if origins is empty.
+func newCORSPolicy(origins, methods string) *corsPolicy {
+	c := &corsPolicy{origins: map[string]bool{}, methods: methods}
+	for _, o := range strings.Split(origins, ",") {
+		switch o = strings.TrimRight(strings.TrimSpace(o), "/"); o {
+		case "":
+		case "*":
+			c.any = true
+		default:
+			c.origins[strings.ToLower(o)] = true
+		}
+	}
+	if !c.any && len(c.origins) == 0 {
+		return nil
+	}
+	return c
+}
+
+// allowed reports whether requests from origin are allowed.
+func (c *corsPolicy) allowed(origin string) bool {
+	return c != nil && origin != "" && (c.any || c.origins[strings.ToLower(origin)])
+}
+
+// wrap returns h with CORS headers for allowed origins, answering preflight
+// requests itself.
+func (c *corsPolicy) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if c.any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, Retry-After")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin allows WebSocket connections from pages served by the exporter,
+// like the default, and from origins the policy allows.
+func (c *corsPolicy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return c.allowed(origin)
+}
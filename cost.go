@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/mhansen/domain"
+)
+
+// Costs of the parts of an ad-hoc search that make it return more listings,
+// and so take more Domain API calls, relative to searching one suburb.
+const (
+	costWholeState   = 50
+	costPostcodeOnly = 2
+	costSurrounding  = 4
+	costAnyBedrooms  = 2
+)
+
+// searchCost estimates how expensive a search is, in Domain API calls relative
+// to searching for listings of a given size in one suburb, and says why.
+func searchCost(rsr domain.ResidentialSearchRequest) (int, []string) {
+	cost, why := 1, []string{}
+	for _, l := range rsr.Locations {
+		switch {
+		case l.Suburb == "" && l.PostCode == "":
+			cost *= costWholeState
+			why = append(why, fmt.Sprintf("the whole state ×%d", costWholeState))
+		case l.Suburb == "":
+			cost *= costPostcodeOnly
+			why = append(why, fmt.Sprintf("the whole postcode ×%d", costPostcodeOnly))
+		}
+		if l.IncludeSurroundingSuburbs {
+			cost *= costSurrounding
+			why = append(why, fmt.Sprintf("surrounding suburbs ×%d", costSurrounding))
+		}
+	}
+	if rsr.MinBedrooms == nil && rsr.MaxBedrooms == nil {
+		cost *= costAnyBedrooms
+		why = append(why, fmt.Sprintf("any number of bedrooms ×%d", costAnyBedrooms))
+	}
+	return cost, why
+}
+
+// checkSearchCost rejects ad-hoc searches that cost more than
+// --web.max-search-cost. With --web.downgrade-searches, searches that would
+// be cheap enough without surrounding suburbs have them turned off instead.
+func (dc domainCollector) checkSearchCost(rsr *domain.ResidentialSearchRequest) error {
+	if dc.maxSearchCost <= 0 {
+		return nil
+	}
+	cost, why := searchCost(*rsr)
+	if cost > dc.maxSearchCost && dc.downgradeSearches {
+		narrowed := *rsr
+		narrowed.Locations = append([]domain.LocationFilter(nil), rsr.Locations...)
+		for i := range narrowed.Locations {
+			narrowed.Locations[i].IncludeSurroundingSuburbs = false
+		}
+		if c, _ := searchCost(narrowed); c <= dc.maxSearchCost {
+			slog.Info("Searching without surrounding suburbs, to keep under --web.max-search-cost", "cost", cost, "downgraded_cost", c)
+			*rsr = narrowed
+			return nil
+		}
+	}
+	if cost <= dc.maxSearchCost {
+		return nil
+	}
+	pe := badParam(reasonSearchCost, "", "",
+		"search costs %d (%s), more than --web.max-search-cost of %d; narrow it down with ?suburb=, ?minBedrooms= or ?maxBedrooms=, or turn off ?includeSurroundingSuburbs=",
+		cost, strings.Join(why, ", "), dc.maxSearchCost)
+	pe.code = http.StatusForbidden
+	return pe
+}
+
+// checkTotalCost rejects requests running several ad-hoc searches, like
+// /compare, that together cost more than --web.max-search-cost, so they can't
+// get around it by splitting a search up.
+func (dc domainCollector) checkTotalCost(rsrs []domain.ResidentialSearchRequest) error {
+	if dc.maxSearchCost <= 0 {
+		return nil
+	}
+	total := 0
+	for _, rsr := range rsrs {
+		cost, _ := searchCost(rsr)
+		total += cost
+	}
+	if total <= dc.maxSearchCost {
+		return nil
+	}
+	pe := badParam(reasonSearchCost, "", "",
+		"searches cost %d together, more than --web.max-search-cost of %d; run fewer at once, or narrow them down with ?minBedrooms= or ?maxBedrooms=",
+		total, dc.maxSearchCost)
+	pe.code = http.StatusForbidden
+	return pe
+}
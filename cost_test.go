@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mhansen/domain"
+)
+
+func float32p(f float32) *float32 { return &f }
+
+func TestSearchCost(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rsr  domain.ResidentialSearchRequest
+		want int
+	}{
+		{
+			name: "suburb with bedrooms",
+			rsr:  domain.ResidentialSearchRequest{MinBedrooms: float32p(2), Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont"}}},
+			want: 1,
+		},
+		{
+			name: "suburb with a maximum only",
+			rsr:  domain.ResidentialSearchRequest{MaxBedrooms: float32p(2), Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont"}}},
+			want: 1,
+		},
+		{
+			name: "suburb with any bedrooms",
+			rsr:  domain.ResidentialSearchRequest{Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont"}}},
+			want: costAnyBedrooms,
+		},
+		{
+			name: "postcode",
+			rsr:  domain.ResidentialSearchRequest{MinBedrooms: float32p(2), Locations: []domain.LocationFilter{{PostCode: "2009"}}},
+			want: costPostcodeOnly,
+		},
+		{
+			name: "whole state",
+			rsr:  domain.ResidentialSearchRequest{MinBedrooms: float32p(2), Locations: []domain.LocationFilter{{State: "NSW"}}},
+			want: costWholeState,
+		},
+		{
+			name: "surrounding suburbs",
+			rsr:  domain.ResidentialSearchRequest{MinBedrooms: float32p(2), Locations: []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont", IncludeSurroundingSuburbs: true}}},
+			want: costSurrounding,
+		},
+		{
+			name: "everything",
+			rsr:  domain.ResidentialSearchRequest{Locations: []domain.LocationFilter{{State: "NSW", IncludeSurroundingSuburbs: true}}},
+			want: costWholeState * costSurrounding * costAnyBedrooms,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, why := searchCost(tc.rsr)
+			if got != tc.want {
+				t.Errorf("searchCost() = %d (%v), want %d", got, why, tc.want)
+			}
+			if got > 1 && len(why) == 0 {
+				t.Errorf("searchCost() = %d without saying why", got)
+			}
+		})
+	}
+}
+
+func TestCheckSearchCost(t *testing.T) {
+	surrounding := domain.ResidentialSearchRequest{
+		MinBedrooms: float32p(2),
+		Locations:   []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont", IncludeSurroundingSuburbs: true}},
+	}
+	for _, tc := range []struct {
+		name      string
+		max       int
+		downgrade bool
+		rsr       domain.ResidentialSearchRequest
+		wantErr   bool
+		// wantSurrounding is whether the search still includes surrounding
+		// suburbs after the check.
+		wantSurrounding bool
+	}{
+		{name: "no limit", max: 0, rsr: surrounding, wantSurrounding: true},
+		{name: "under the limit", max: costSurrounding, rsr: surrounding, wantSurrounding: true},
+		{name: "over the limit", max: costSurrounding - 1, rsr: surrounding, wantErr: true, wantSurrounding: true},
+		{name: "downgraded", max: costSurrounding - 1, downgrade: true, rsr: surrounding},
+		{
+			name:      "too dear even downgraded",
+			max:       costSurrounding - 1,
+			downgrade: true,
+			rsr:       domain.ResidentialSearchRequest{Locations: []domain.LocationFilter{{State: "NSW", IncludeSurroundingSuburbs: true}}},
+			wantErr:   true, wantSurrounding: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dc := domainCollector{maxSearchCost: tc.max, downgradeSearches: tc.downgrade}
+			rsr := tc.rsr
+			rsr.Locations = append([]domain.LocationFilter(nil), tc.rsr.Locations...)
+			err := dc.checkSearchCost(&rsr)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("checkSearchCost() = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				var pe *paramError
+				if !errors.As(err, &pe) || pe.code != http.StatusForbidden || pe.Reason != reasonSearchCost {
+					t.Errorf("checkSearchCost() = %#v, want a 403 %s paramError", err, reasonSearchCost)
+				}
+			}
+			if got := rsr.Locations[0].IncludeSurroundingSuburbs; got != tc.wantSurrounding {
+				t.Errorf("includes surrounding suburbs = %v after the check, want %v", got, tc.wantSurrounding)
+			}
+			if !tc.rsr.Locations[0].IncludeSurroundingSuburbs {
+				t.Errorf("checkSearchCost() changed the caller's locations")
+			}
+		})
+	}
+}
+
+func TestCheckTotalCost(t *testing.T) {
+	suburb := func(name string) domain.ResidentialSearchRequest {
+		return domain.ResidentialSearchRequest{MinBedrooms: float32p(2), Locations: []domain.LocationFilter{{State: "VIC", Suburb: name}}}
+	}
+	ten := make([]domain.ResidentialSearchRequest, 10)
+	for i := range ten {
+		ten[i] = suburb("Richmond")
+	}
+	for _, tc := range []struct {
+		name    string
+		max     int
+		rsrs    []domain.ResidentialSearchRequest
+		wantErr bool
+	}{
+		{name: "no limit", max: 0, rsrs: ten},
+		{name: "each under, together under", max: 10, rsrs: ten},
+		{name: "each under, together over", max: 9, rsrs: ten, wantErr: true},
+		{name: "one", max: 1, rsrs: ten[:1]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dc := domainCollector{maxSearchCost: tc.max}
+			if err := dc.checkTotalCost(tc.rsrs); (err != nil) != tc.wantErr {
+				t.Errorf("checkTotalCost() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
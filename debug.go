@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Contact details agents put in listings' headlines and display prices, like
+// "Contact agent 0412 345 678", which /debug/listings scrubs unless
+// --debug.raw-payloads is given.
+var (
+	emailRE = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	// Australian mobiles, landlines, 13, 1300 and 1800 numbers, and +61
+	// numbers, with or without spaces, dashes or brackets.
+	phoneRE = regexp.MustCompile(`(\+61[ -]?\(?0?\)?[ -]?[2-478]|\(0[2-478]\)|\b0[2-478])([ -]?\d){8}\b|\b1[38]00([ -]?\d){6}\b|\b13([ -]?\d){4}\b`)
+)
+
+// scrubContacts replaces email addresses and phone numbers in s.
+func scrubContacts(s string) string {
+	s = emailRE.ReplaceAllString(s, "[email]")
+	return phoneRE.ReplaceAllString(s, "[phone]")
+}
+
+var debugListingsTemplate = template.Must(template.New("debug").Parse(`<!doctype html>
+<title>Listings{{with .Key}}: {{.}}{{end}}</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; }
+</style>
+{{if .Key -}}
+<h1>{{.Key}}</h1>
+{{if .Ran -}}
+<p>{{len .Listings}} listings, last fetched {{.Updated.Format "2006-01-02 15:04:05 MST"}}.</p>
+<table>
+<tr><th>Address</th><th>Headline</th><th>Type</th><th>Price</th><th>Beds</th><th>Baths</th><th>Cars</th><th>First seen</th><th>Link</th></tr>
+{{range .Listings -}}
+<tr><td>{{.Address}}</td><td>{{.Headline}}</td><td>{{.PropertyType}}</td><td>{{.DisplayPrice}}{{if .Price}} ({{.Price}}){{end}}</td><td>{{.Bedrooms}}</td><td>{{.Bathrooms}}</td><td>{{.Carspaces}}</td><td>{{.FirstSeen.Format "2006-01-02 15:04"}}</td><td><a href="{{.URL}}">{{.ID}}</a></td></tr>
+{{end -}}
+</table>
+{{if .Payloads}}<p><a href="?key={{.Key}}&amp;raw=true">Listings as JSON</a></p>
+{{end -}}
+{{- else -}}
+<p>This search hasn't run since the exporter started.</p>
+{{- end}}
+{{- else -}}
+<h1>Listings</h1>
+<p>Searches that have run since the exporter started:</p>
+<ul>
+{{range .Keys}}<li><a href="?key={{.}}">{{.}}</a></li>
+{{end -}}
+</ul>
+{{- end}}
+`))
+
+// debugListingsHandler shows the listings a search found the last time it
+// ran, to check what's behind a metric. It doesn't search, so it costs no API
+// calls. Pick the search with the same parameters as /listings, or with
+// ?key=<search>; without either it lists the searches that have run.
+//
+// Agents' contact details are scrubbed from headlines and display prices, the
+// listings' free text, and secrets from the whole page. With
+// --debug.raw-payloads, ?key=<search>&raw=true returns the listings as JSON
+// instead, still without secrets.
+func (dc domainCollector) debugListingsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := struct {
+		Key      string
+		Keys     []string
+		Ran      bool
+		Updated  time.Time
+		Listings []seenListing
+		Payloads bool
+	}{Key: q.Get("key"), Payloads: dc.seen.keepPayloads}
+	if data.Key != "" {
+		if err := checkParams(q, "key", "raw"); err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+		if q.Has("raw") {
+			dc.debugPayloads(w, data.Key, q.Get("raw"))
+			return
+		}
+	} else if len(q) > 0 {
+		module, rsr, err := dc.searchFromQuery(q)
+		if err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+		data.Key = searchKey(module, rsr)
+	}
+	if data.Key != "" {
+		data.Listings, data.Updated, data.Ran = dc.seen.current(data.Key)
+		if !dc.seen.keepPayloads {
+			for i := range data.Listings {
+				data.Listings[i].Headline = scrubContacts(data.Listings[i].Headline)
+				data.Listings[i].DisplayPrice = scrubContacts(data.Listings[i].DisplayPrice)
+			}
+		}
+	} else {
+		data.Keys = dc.seen.keys()
+	}
+	var buf bytes.Buffer
+	if err := debugListingsTemplate.Execute(&buf, data); err != nil {
+		slog.Error("couldn't render listings", "err", err)
+		http.Error(w, "couldn't render listings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(secrets.redact(buf.String())))
+}
+
+// debugPayloads responds with the listings the search key found the last
+// time it ran, as JSON like Domain's. They're the listings as decoded by the
+// domain package, not Domain's responses byte for byte, so fields it doesn't
+// know about are left out.
+func (dc domainCollector) debugPayloads(w http.ResponseWriter, key, raw string) {
+	if raw != "true" {
+		dc.badRequest(w, badParam(reasonInvalidValue, "raw", raw, "raw must be true"))
+		return
+	}
+	if !dc.seen.keepPayloads {
+		pe := badParam(reasonRawPayloads, "raw", raw, "raw payloads include agents' contact details, so are only kept with --debug.raw-payloads")
+		pe.code = http.StatusForbidden
+		dc.badRequest(w, pe)
+		return
+	}
+	listings, ok := dc.seen.payloads(key)
+	if !ok {
+		http.Error(w, "this search hasn't run since the exporter started", http.StatusNotFound)
+		return
+	}
+	b, err := json.MarshalIndent(listings, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(secrets.redact(string(b))))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// digest collects listing events and emails a summary of them once a day.
+type digest struct {
+	addr     string
+	auth     smtp.Auth
+	from     string
+	to       []string
+	at       time.Duration // Time of day to send, since midnight.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	// leader, if set, decides whether this replica sends the digest.
+	leader *leader
+
+	mu     sync.Mutex
+	events []listingEvent
+
+	sent *prometheus.CounterVec
+}
+
+func newDigest(addr, username, password, from string, to []string, at string) (*digest, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("bad time of day %q, want HH:MM", at)
+	}
+	d := &digest{
+		addr:     addr,
+		from:     from,
+		to:       to,
+		at:       time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute,
+		sendMail: smtp.SendMail,
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_digests_total",
+			Help: "Number of daily digest emails sent, by whether they were delivered.",
+		}, []string{"result"}),
+	}
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		d.auth = smtp.PlainAuth("", username, password, host)
+	}
+	d.sent.WithLabelValues("success")
+	d.sent.WithLabelValues("failure")
+	return d, nil
+}
+
+// add records events for the next digest.
+func (d *digest) add(events []listingEvent) {
+	if d == nil || len(events) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, events...)
+}
+
+// next returns when the digest after now should be sent.
+func (d *digest) next(now time.Time) time.Time {
+	y, m, day := now.Date()
+	t := time.Date(y, m, day, 0, 0, 0, 0, now.Location()).Add(d.at)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// run sends a digest every day until ctx is done. Days without any changes
+// are skipped.
+func (d *digest) run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(d.next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		d.mu.Lock()
+		events := d.events
+		d.events = nil
+		d.mu.Unlock()
+		if len(events) == 0 {
+			slog.Info("no listing changes, not sending digest")
+			continue
+		}
+		if !d.leader.isLeading() {
+			slog.Info("not the leader, not sending digest")
+			continue
+		}
+		if err := d.sendMail(d.addr, d.auth, d.from, d.to, d.message(events, time.Now())); err != nil {
+			d.sent.WithLabelValues("failure").Inc()
+			slog.Error("error sending digest", "err", secrets.redact(err.Error()))
+			continue
+		}
+		d.sent.WithLabelValues("success").Inc()
+		slog.Info("sent digest", "events", len(events), "to", strings.Join(d.to, ","))
+	}
+}
+
+// message formats events as a plain text email, grouped by search.
+func (d *digest) message(events []listingEvent, now time.Time) []byte {
+	bySearch := map[string][]listingEvent{}
+	for _, e := range events {
+		bySearch[e.Search] = append(bySearch[e.Search], e)
+	}
+	var searches []string
+	for s := range bySearch {
+		searches = append(searches, s)
+	}
+	sort.Strings(searches)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", d.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(d.to, ", "))
+	fmt.Fprintf(&b, "Subject: Domain listings digest for %s\r\n", now.Format("Mon 2 Jan 2006"))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, s := range searches {
+		fmt.Fprintf(&b, "%s\r\n", s)
+		for _, section := range []struct {
+			title string
+			types []string
+		}{
+			{"New", []string{eventNew}},
+			{"Price changes", []string{eventPriceDrop, eventPriceRise}},
+			{"Removed", []string{eventRemoved}},
+		} {
+			var lines []string
+			for _, e := range bySearch[s] {
+				for _, t := range section.types {
+					if e.Type != t {
+						continue
+					}
+					line := fmt.Sprintf("  %s, %v bed, %s", e.Listing.Address, e.Listing.Bedrooms, e.Listing.DisplayPrice)
+					if e.PreviousPrice != 0 {
+						line += fmt.Sprintf(" (was $%v)", e.PreviousPrice)
+					}
+					lines = append(lines, line+"\r\n    "+e.Listing.URL)
+				}
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\r\n%s (%d):\r\n%s\r\n", section.title, len(lines), strings.Join(lines, "\r\n"))
+		}
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
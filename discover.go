@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mhansen/domain"
+)
+
+var moduleUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// discoverSuburbs expands each of modules, a search of an area or region,
+// into a search per suburb, so that an area can be monitored with one search
+// file. Each suburb's search is the same as the module's, but only for that
+// suburb, and is named <module>_<suburb>, like inner_west_balmain. Searches
+// that already exist with those names are left alone.
+//
+// The suburbs are the ones with listings in the module's search right now,
+// so quiet suburbs can be missed. It returns how many searches were added.
+func (dc domainCollector) discoverSuburbs(ctx context.Context, modules []string) (int, error) {
+	added := 0
+	for _, module := range modules {
+		rsr, ok := dc.searches.get(module)
+		if !ok {
+			return added, fmt.Errorf("unknown module %q", module)
+		}
+		start := time.Now()
+		listings, err := dc.client(ctx).SearchResidential(rsr)
+		if err != nil {
+			return added, fmt.Errorf("couldn't search %s: %w", module, redactError(err))
+		}
+		type suburb struct{ state, name, postcode string }
+		var suburbs []suburb
+		seen := map[suburb]bool{}
+		names := map[string]int{}
+		for _, l := range listings {
+			p := l.Listing.PropertyDetails
+			s := suburb{p.State, p.Suburb, p.Postcode}
+			if s.name == "" || seen[s] {
+				continue
+			}
+			seen[s] = true
+			suburbs = append(suburbs, s)
+			names[s.name]++
+		}
+		n := 0
+		for _, s := range suburbs {
+			name := module + "_" + strings.Trim(moduleUnsafe.ReplaceAllString(strings.ToLower(s.name), "_"), "_")
+			// Suburbs with the same name in different postcodes need
+			// telling apart.
+			if names[s.name] > 1 {
+				name += "_" + s.postcode
+			}
+			r := rsr
+			r.PageNumber = 0
+			r.Locations = []domain.LocationFilter{{State: s.state, Suburb: s.name, PostCode: s.postcode}}
+			if dc.searches.add(name, r) {
+				n++
+			}
+		}
+		slog.Info("discovered suburbs", "module", module, "suburbs", len(suburbs), "added", n, "duration", time.Since(start))
+		added += n
+	}
+	return added, nil
+}
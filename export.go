@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/mhansen/domain"
+)
+
+// csvHandler serves one row per listing found by a search, for pasting into a
+// spreadsheet.
+func (dc domainCollector) csvHandler(w http.ResponseWriter, r *http.Request) {
+	module, rsr, err := dc.searchFromQuery(r.URL.Query())
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	listings, err := dc.search(r.Context(), module, rsr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error searching domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	name := module
+	if name == "" {
+		name = "listings"
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	writeCSV(w, listings)
+}
+
+// writeCSV writes one row per listing to w.
+func writeCSV(w io.Writer, listings []domain.SearchResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "address", "suburb", "price", "bedrooms", "bathrooms", "dateListed", "url"})
+	for _, l := range listings {
+		s := summarize(l)
+		cw.Write([]string{
+			strconv.Itoa(int(s.ID)),
+			s.Address,
+			s.Suburb,
+			s.DisplayPrice,
+			fmt.Sprintf("%v", s.Bedrooms),
+			fmt.Sprintf("%v", s.Bathrooms),
+			s.DateListed,
+			s.URL,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// geoJSONFeature is a GeoJSON (RFC 7946) point feature for one listing.
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float32 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties listingSummary `json:"properties"`
+}
+
+// geoJSONHandler serves the listings found by a search as a GeoJSON
+// FeatureCollection of points, for putting on a map. Listings without a
+// location are left out.
+func (dc domainCollector) geoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	module, rsr, err := dc.searchFromQuery(r.URL.Query())
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	listings, err := dc.search(r.Context(), module, rsr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error searching domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	features := []geoJSONFeature{}
+	for _, l := range listings {
+		s := summarize(l)
+		if s.Latitude == 0 && s.Longitude == 0 {
+			continue
+		}
+		f := geoJSONFeature{Type: "Feature", Properties: s}
+		f.Geometry.Type = "Point"
+		f.Geometry.Coordinates = [2]float32{s.Longitude, s.Latitude}
+		features = append(features, f)
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
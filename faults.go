@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// faults injects synthetic failures, latency and price jumps, so alerting
+// rules and dashboards can be tested without waiting for Domain to go down or
+// the market to move. It's for development only.
+type faults struct {
+	errors     float64 // Fraction of Domain API calls that fail.
+	latency    time.Duration
+	priceJumps float64 // Fraction of listings whose price jumps each search.
+	injected   *prometheus.CounterVec
+}
+
+// newFaults returns faults with the given settings, or nil if they're all
+// off.
+func newFaults(errors float64, latency time.Duration, priceJumps float64) *faults {
+	if errors <= 0 && latency <= 0 && priceJumps <= 0 {
+		return nil
+	}
+	return &faults{
+		errors:     errors,
+		latency:    latency,
+		priceJumps: priceJumps,
+		injected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_injected_faults_total",
+			Help: "Synthetic faults injected by the --dev.inject-* flags, by type.",
+		}, []string{"type"}),
+	}
+}
+
+// faultTransport delays Domain API calls, and fails some of them with a 503
+// without making them, so they don't cost quota.
+type faultTransport struct {
+	base http.RoundTripper
+	f    *faults
+}
+
+func (t faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.f.latency > 0 {
+		t.f.injected.WithLabelValues("latency").Inc()
+		select {
+		case <-time.After(t.f.latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if rand.Float64() < t.f.errors {
+		t.f.injected.WithLabelValues("error").Inc()
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader("fault injected by --dev.inject-errors")),
+			Request:    req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// jumpPrices returns a copy of listings with the price of some moved up or
+// down by 10-50%. The jumps are only for the metrics made from the copy, so
+// they don't set off notifications or end up in history. f may be nil.
+func (f *faults) jumpPrices(listings []domain.SearchResult) []domain.SearchResult {
+	if f == nil || f.priceJumps <= 0 {
+		return listings
+	}
+	jumped := append([]domain.SearchResult(nil), listings...)
+	for i := range jumped {
+		if rand.Float64() >= f.priceJumps {
+			continue
+		}
+		p := &jumped[i].Listing.PriceDetails
+		price := collector.ParsePrice(*p)
+		if price == 0 {
+			continue
+		}
+		change := 0.1 + 0.4*rand.Float64()
+		if rand.Intn(2) == 0 {
+			change = -change
+		}
+		p.Price = int32(price * (1 + change))
+		p.DisplayPrice = fmt.Sprintf("$%d (injected)", p.Price)
+		f.injected.WithLabelValues("price_jump").Inc()
+	}
+	return jumped
+}
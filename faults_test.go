@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mhansen/domain"
+)
+
+func TestJumpPrices(t *testing.T) {
+	listings := []domain.SearchResult{testListing(1, 500), testListing(2, 0), testListing(3, 700)}
+	orig := append([]domain.SearchResult(nil), listings...)
+
+	var off *faults
+	if got := off.jumpPrices(listings); !reflect.DeepEqual(got, orig) {
+		t.Errorf("jumpPrices() with faults off = %+v, want them unchanged", got)
+	}
+
+	f := newFaults(0, 0, 1)
+	jumped := f.jumpPrices(listings)
+	if !reflect.DeepEqual(listings, orig) {
+		t.Errorf("jumpPrices() changed the listings it was given")
+	}
+	for i, l := range jumped {
+		was, now := orig[i].Listing.PriceDetails.Price, l.Listing.PriceDetails.Price
+		if was == 0 {
+			if now != 0 {
+				t.Errorf("listing %d without a price got one, %d", l.Listing.ID, now)
+			}
+			continue
+		}
+		if change := math.Abs(float64(now-was) / float64(was)); change < 0.09 || change > 0.51 {
+			t.Errorf("listing %d price went from %d to %d, want a 10-50%% jump", l.Listing.ID, was, now)
+		}
+	}
+}
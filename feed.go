@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// feedHandler serves an Atom feed of the listings a search has found since
+// it first ran, newest first. Each fetch of the feed runs the search, so
+// listings show up in the feed the first time the feed reader polls after
+// they're listed. The listings already there when the search first runs
+// aren't new, so the feed starts empty.
+func (dc domainCollector) feedHandler(w http.ResponseWriter, r *http.Request) {
+	module, rsr, err := dc.searchFromQuery(r.URL.Query())
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	// Searching records any new listings.
+	if _, err := dc.search(r.Context(), module, rsr); err != nil {
+		http.Error(w, fmt.Sprintf("error searching domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	key := searchKey(module, rsr)
+
+	title := "New listings"
+	if module != "" {
+		title += ": " + module
+	}
+	feed := atomFeed{
+		ID:      "urn:domain-exporter:" + key,
+		Title:   title,
+		Updated: now.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: requestURL(r), Rel: "self"},
+	}
+	for _, l := range dc.seen.recent(key) {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("urn:domain-listing:%d", l.ID),
+			Title:   fmt.Sprintf("%s: %s", l.Address, l.DisplayPrice),
+			Updated: l.FirstSeen.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: l.URL},
+			Summary: fmt.Sprintf("%s. %v bed, %v bath, %v car. %s", l.PropertyType, l.Bedrooms, l.Bathrooms, l.Carspaces, l.Headline),
+		})
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		searchLogger(r.Context(), module, rsr).Error("error writing feed", "err", err)
+	}
+}
+
+// requestURL returns the absolute URL r was made to, as feeds need.
+func requestURL(r *http.Request) string {
+	u := *r.URL
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.Host = r.Host
+	return u.String()
+}
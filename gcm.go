@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2/google"
+)
+
+// gcmMaxTimeSeries is the most time series Cloud Monitoring accepts in one
+// request.
+const gcmMaxTimeSeries = 200
+
+// gcmWriter writes metrics to Google Cloud Monitoring as custom metrics,
+// named custom.googleapis.com/domain/<metric>, on the global resource.
+// Credentials are Application Default Credentials.
+type gcmWriter struct {
+	project string
+	client  *http.Client
+	// started is the start time of counters without a created timestamp.
+	started time.Time
+}
+
+func newGCMWriter(ctx context.Context, project string) (*gcmWriter, error) {
+	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/monitoring.write")
+	if err != nil {
+		return nil, err
+	}
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("couldn't find the project from the default credentials, pass --push.gcm-project")
+	}
+	c, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/monitoring.write")
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = 30 * time.Second
+	return &gcmWriter{project: project, client: c, started: time.Now()}, nil
+}
+
+type gcmTimeSeries struct {
+	Metric     gcmLabelled `json:"metric"`
+	Resource   gcmLabelled `json:"resource"`
+	MetricKind string      `json:"metricKind"`
+	ValueType  string      `json:"valueType"`
+	Points     []gcmPoint  `json:"points"`
+}
+
+type gcmLabelled struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+type gcmPoint struct {
+	Interval struct {
+		// StartTime is only for CUMULATIVE points.
+		StartTime string `json:"startTime,omitempty"`
+		EndTime   string `json:"endTime"`
+	} `json:"interval"`
+	Value struct {
+		DoubleValue float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// Push implements pusher.
+func (gw *gcmWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	samples, err := gatherSamples(g, map[string]string{"module": module})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var series []gcmTimeSeries
+	for _, s := range samples {
+		labels := map[string]string{}
+		for _, l := range s.Labels {
+			labels[l.Name] = l.Value
+		}
+		var p gcmPoint
+		p.Interval.EndTime = now.Format(time.RFC3339Nano)
+		p.Value.DoubleValue = s.Value
+		kind := "GAUGE"
+		if s.Counter {
+			// Counters are CUMULATIVE, with when they started counting,
+			// so Cloud Monitoring can work out rates across restarts.
+			kind = "CUMULATIVE"
+			start := s.Created
+			if start.IsZero() {
+				start = gw.started
+			}
+			if !start.Before(now) {
+				start = now.Add(-time.Millisecond)
+			}
+			p.Interval.StartTime = start.UTC().Format(time.RFC3339Nano)
+		}
+		ts := gcmTimeSeries{
+			Metric:     gcmLabelled{Type: "custom.googleapis.com/domain/" + s.Name, Labels: labels},
+			Resource:   gcmLabelled{Type: "global", Labels: map[string]string{"project_id": gw.project}},
+			MetricKind: kind,
+			ValueType:  "DOUBLE",
+			Points:     []gcmPoint{p},
+		}
+		series = append(series, ts)
+	}
+	for len(series) > 0 {
+		n := len(series)
+		if n > gcmMaxTimeSeries {
+			n = gcmMaxTimeSeries
+		}
+		if err := gw.write(ctx, series[:n]); err != nil {
+			return err
+		}
+		series = series[n:]
+	}
+	return nil
+}
+
+func (gw *gcmWriter) write(ctx context.Context, series []gcmTimeSeries) error {
+	body, err := json.Marshal(map[string]interface{}{"timeSeries": series})
+	if err != nil {
+		return err
+	}
+	u := "https://monitoring.googleapis.com/v3/projects/" + gw.project + "/timeSeries"
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := gw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("timeSeries.create returned %s: %s", resp.Status, b)
+	}
+	return nil
+}
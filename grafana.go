@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The Grafana JSON datasource targets for each search with history are
+// <search>:count and <search>:median_price time series, and <search>:listings,
+// a table of the listings the search last found.
+// https://grafana.com/grafana/plugins/simpod-json-datasource/
+var grafanaTargetKinds = []string{"count", "median_price", "listings"}
+
+// grafanaHandler serves the Grafana JSON datasource API under /grafana/. The
+// time series and tables come from the history database, and the annotations
+// from recent listing events.
+func (dc domainCollector) grafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/grafana/", func(w http.ResponseWriter, r *http.Request) {
+		// Grafana's "Test" button.
+		if r.URL.Path != "/grafana/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	})
+	mux.HandleFunc("/grafana/search", dc.grafanaSearch)
+	mux.HandleFunc("/grafana/metrics", dc.grafanaSearch)
+	mux.HandleFunc("/grafana/query", dc.grafanaQuery)
+	mux.HandleFunc("/grafana/annotations", dc.grafanaAnnotations)
+	return mux
+}
+
+func (dc domainCollector) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	if dc.history == nil {
+		writeJSONError(w, http.StatusNotFound, "time series need --history.db")
+		return
+	}
+	searches, err := dc.history.searches(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	targets := []string{}
+	for _, s := range searches {
+		for _, k := range grafanaTargetKinds {
+			targets = append(targets, s+":"+k)
+		}
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+type grafanaQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+func (dc domainCollector) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if dc.history == nil {
+		writeJSONError(w, http.StatusNotFound, "time series need --history.db")
+		return
+	}
+	var req grafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, bodyErrorStatus(err), fmt.Sprintf("bad query: %v", err))
+		return
+	}
+	resp := []interface{}{}
+	for _, t := range req.Targets {
+		i := strings.LastIndex(t.Target, ":")
+		if i < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("bad target %q", t.Target))
+			return
+		}
+		search, kind := t.Target[:i], t.Target[i+1:]
+		snapshots, err := dc.history.snapshots(r.Context(), search, req.Range.From, req.Range.To)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		points := summarizeSnapshots(snapshots)
+		switch kind {
+		case "count", "median_price":
+			ts := grafanaTimeSeries{Target: t.Target, Datapoints: [][2]float64{}}
+			for _, p := range points {
+				v := float64(p.Count)
+				if kind == "median_price" {
+					if p.MedianPrice == 0 {
+						continue
+					}
+					v = p.MedianPrice
+				}
+				ts.Datapoints = append(ts.Datapoints, [2]float64{v, float64(p.Time.UnixMilli())})
+			}
+			resp = append(resp, ts)
+		case "listings":
+			resp = append(resp, latestListingsTable(snapshots))
+		default:
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("bad target %q, want <search>:count, :median_price or :listings", t.Target))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// latestListingsTable returns a table of the most recent snapshot's listings.
+func latestListingsTable(snapshots []listingSnapshot) grafanaTable {
+	t := grafanaTable{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{"Address", "string"}, {"Suburb", "string"}, {"Type", "string"}, {"Bedrooms", "number"},
+			{"Bathrooms", "number"}, {"Price", "string"}, {"Listed", "string"}, {"URL", "string"},
+		},
+		Rows: [][]interface{}{},
+	}
+	if len(snapshots) == 0 {
+		return t
+	}
+	last := snapshots[len(snapshots)-1].ObservedAt
+	var latest []listingSnapshot
+	for _, s := range snapshots {
+		if s.ObservedAt.Equal(last) {
+			latest = append(latest, s)
+		}
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Address < latest[j].Address })
+	for _, s := range latest {
+		t.Rows = append(t.Rows, []interface{}{s.Address, s.Suburb, s.PropertyType, s.Bedrooms, s.Bathrooms, s.DisplayPrice, s.DateListed, s.URL})
+	}
+	return t
+}
+
+type grafanaAnnotationRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+var grafanaEventTitles = map[string]string{
+	eventNew:       "New listing",
+	eventPriceDrop: "Price drop",
+	eventPriceRise: "Price rise",
+	eventRemoved:   "Listing removed",
+}
+
+// grafanaAnnotations serves recent listing events as annotations. The
+// annotation's query can list the event types and searches to show,
+// separated by spaces or commas, like "price_drop module:pyrmont_rent".
+func (dc domainCollector) grafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req grafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, bodyErrorStatus(err), fmt.Sprintf("bad annotation query: %v", err))
+		return
+	}
+	types, searches := map[string]bool{}, map[string]bool{}
+	for _, f := range strings.FieldsFunc(req.Annotation.Query, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if _, ok := grafanaEventTitles[f]; ok {
+			types[f] = true
+		} else {
+			searches[f] = true
+		}
+	}
+	annotations := []grafanaAnnotation{}
+	for _, e := range dc.seen.recentEvents(req.Range.From, req.Range.To) {
+		if len(types) > 0 && !types[e.Type] || len(searches) > 0 && !searches[e.Search] {
+			continue
+		}
+		text := fmt.Sprintf(`%s, %v bed, %s <a href="%s">%s</a>`, html.EscapeString(e.Listing.Address), e.Listing.Bedrooms,
+			html.EscapeString(e.Listing.DisplayPrice), html.EscapeString(e.Listing.URL), html.EscapeString(e.Listing.URL))
+		if e.PreviousPrice != 0 {
+			text += fmt.Sprintf(" (was $%v)", e.PreviousPrice)
+		}
+		annotations = append(annotations, grafanaAnnotation{
+			Time:  e.Time.UnixMilli(),
+			Title: grafanaEventTitles[e.Type],
+			Text:  text,
+			Tags:  []string{e.Type, e.Search, e.Listing.Suburb},
+		})
+	}
+	writeJSON(w, http.StatusOK, annotations)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	graphiteUnsafe    = regexp.MustCompile(`[^A-Za-z0-9_:-]+`)
+	graphiteTagUnsafe = regexp.MustCompile(`[;~!^=\s]+`)
+)
+
+// graphiteWriter pushes metrics to Graphite with the plaintext protocol.
+// Metrics are named <prefix>.<module>.<metric>.<label>.<value>..., or with
+// tags, <prefix>.<metric>;module=<module>;<label>=<value>....
+type graphiteWriter struct {
+	addr   string
+	prefix string
+	tags   bool
+}
+
+// Push implements pusher.
+func (gw *graphiteWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	var extra map[string]string
+	if gw.tags {
+		extra = map[string]string{"module": module}
+	}
+	samples, err := gatherSamples(g, extra)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	ts := time.Now().Unix()
+	for _, s := range samples {
+		var path []string
+		if gw.prefix != "" {
+			path = append(path, gw.prefix)
+		}
+		if gw.tags {
+			path = append(path, s.Name)
+			name := strings.Join(path, ".")
+			for _, l := range s.Labels {
+				// Graphite doesn't allow empty tag values.
+				if l.Value != "" {
+					name += ";" + l.Name + "=" + graphiteTagUnsafe.ReplaceAllString(l.Value, "_")
+				}
+			}
+			fmt.Fprintf(&b, "%s %s %d\n", name, strconv.FormatFloat(s.Value, 'g', -1, 64), ts)
+			continue
+		}
+		path = append(path, graphiteUnsafe.ReplaceAllString(module, "_"), s.Name)
+		for _, l := range s.Labels {
+			v := graphiteUnsafe.ReplaceAllString(l.Value, "_")
+			if v == "" {
+				v = "none"
+			}
+			path = append(path, l.Name, v)
+		}
+		fmt.Fprintf(&b, "%s %s %d\n", strings.Join(path, "."), strconv.FormatFloat(s.Value, 'g', -1, 64), ts)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", gw.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	_, err = conn.Write(b.Bytes())
+	return err
+}
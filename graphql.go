@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// graphqlListing is a listing in a GraphQL response. FirstSeen is only known
+// for listings the exporter has seen itself.
+type graphqlListing struct {
+	listingSummary
+	FirstSeen *time.Time
+}
+
+// graphqlSearch is a search in a GraphQL response, identified by its
+// searchKey.
+type graphqlSearch string
+
+// graphqlHandler serves a read-only GraphQL API over the listings and events
+// the exporter remembers, and the history database if there is one. It never
+// runs searches, so queries cost no API calls.
+func (dc domainCollector) graphqlHandler() http.Handler {
+	schema, err := dc.graphqlSchema()
+	if err != nil {
+		// The schema is fixed, so this is a bug.
+		panic(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if v := r.URL.Query().Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("bad variables: %v", err))
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, bodyErrorStatus(err), fmt.Sprintf("bad GraphQL request: %v", err))
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "use GET or POST")
+			return
+		}
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        r.Context(),
+		})
+		if len(result.Errors) > 0 {
+			slog.Debug("GraphQL query failed", "errors", fmt.Sprint(result.Errors))
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+func (dc domainCollector) graphqlSchema() (graphql.Schema, error) {
+	listingFields := graphql.Fields{}
+	for name, f := range map[string]struct {
+		typ graphql.Output
+		get func(graphqlListing) interface{}
+	}{
+		"id":           {graphql.Int, func(l graphqlListing) interface{} { return l.ID }},
+		"headline":     {graphql.String, func(l graphqlListing) interface{} { return l.Headline }},
+		"address":      {graphql.String, func(l graphqlListing) interface{} { return l.Address }},
+		"suburb":       {graphql.String, func(l graphqlListing) interface{} { return l.Suburb }},
+		"state":        {graphql.String, func(l graphqlListing) interface{} { return l.State }},
+		"postcode":     {graphql.String, func(l graphqlListing) interface{} { return l.Postcode }},
+		"propertyType": {graphql.String, func(l graphqlListing) interface{} { return l.PropertyType }},
+		"bedrooms":     {graphql.Float, func(l graphqlListing) interface{} { return l.Bedrooms }},
+		"bathrooms":    {graphql.Float, func(l graphqlListing) interface{} { return l.Bathrooms }},
+		"carspaces":    {graphql.Int, func(l graphqlListing) interface{} { return l.Carspaces }},
+		"displayPrice": {graphql.String, func(l graphqlListing) interface{} { return l.DisplayPrice }},
+		"price": {graphql.Float, func(l graphqlListing) interface{} {
+			if l.Price == 0 {
+				return nil
+			}
+			return l.Price
+		}},
+		"dateListed": {graphql.String, func(l graphqlListing) interface{} { return l.DateListed }},
+		"latitude":   {graphql.Float, func(l graphqlListing) interface{} { return l.Latitude }},
+		"longitude":  {graphql.Float, func(l graphqlListing) interface{} { return l.Longitude }},
+		"url":        {graphql.String, func(l graphqlListing) interface{} { return l.URL }},
+		"firstSeen": {graphql.DateTime, func(l graphqlListing) interface{} {
+			if l.FirstSeen == nil {
+				return nil
+			}
+			return *l.FirstSeen
+		}},
+	} {
+		get := f.get
+		listingFields[name] = &graphql.Field{Type: f.typ, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(graphqlListing)), nil
+		}}
+	}
+	listingType := graphql.NewObject(graphql.ObjectConfig{Name: "Listing", Fields: listingFields})
+
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Event",
+		Fields: graphql.Fields{
+			"type":   &graphql.Field{Type: graphql.String},
+			"search": &graphql.Field{Type: graphql.String},
+			"time":   &graphql.Field{Type: graphql.DateTime},
+			"price": &graphql.Field{Type: graphql.Float, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if e := p.Source.(listingEvent); e.Price != 0 {
+					return e.Price, nil
+				}
+				return nil, nil
+			}},
+			"previousPrice": &graphql.Field{Type: graphql.Float, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if e := p.Source.(listingEvent); e.PreviousPrice != 0 {
+					return e.PreviousPrice, nil
+				}
+				return nil, nil
+			}},
+			"listing": &graphql.Field{Type: listingType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return graphqlListing{listingSummary: p.Source.(listingEvent).Listing}, nil
+			}},
+		},
+	})
+
+	historyPointType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "HistoryPoint",
+		Fields: graphql.Fields{
+			"time":  &graphql.Field{Type: graphql.DateTime},
+			"count": &graphql.Field{Type: graphql.Int},
+			"medianPrice": &graphql.Field{Type: graphql.Float, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if hp := p.Source.(historyPoint); hp.MedianPrice != 0 {
+					return hp.MedianPrice, nil
+				}
+				return nil, nil
+			}},
+		},
+	})
+
+	eventsArgs := graphql.FieldConfigArgument{
+		"types": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String), Description: "Event types to return, all of them if empty."},
+		"since": &graphql.ArgumentConfig{Type: graphql.DateTime, Description: "Only return events after this time."},
+	}
+	// events returns the remembered events of search, or of every search if
+	// it's empty, that match p's arguments.
+	events := func(search string, p graphql.ResolveParams) ([]listingEvent, error) {
+		since, _ := p.Args["since"].(time.Time)
+		var types []string
+		if ts, ok := p.Args["types"].([]interface{}); ok {
+			for _, t := range ts {
+				s, _ := t.(string)
+				if !slices.Contains(eventTypes, s) {
+					return nil, fmt.Errorf("unknown event type %q, want one of %s", s, strings.Join(eventTypes, ", "))
+				}
+				types = append(types, s)
+			}
+		}
+		var matched []listingEvent
+		for _, e := range dc.seen.recentEvents(since, time.Now()) {
+			if search != "" && e.Search != search || len(types) > 0 && !slices.Contains(types, e.Type) {
+				continue
+			}
+			matched = append(matched, e)
+		}
+		return matched, nil
+	}
+
+	searchType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Search",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return string(p.Source.(graphqlSearch)), nil
+			}},
+			"updated": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the search last ran, or null if it hasn't since the exporter started.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if _, updated, ok := dc.seen.current(string(p.Source.(graphqlSearch))); ok {
+						return updated, nil
+					}
+					return nil, nil
+				},
+			},
+			"listings": &graphql.Field{
+				Type:        graphql.NewList(listingType),
+				Description: "The listings the search found the last time it ran, newest first.",
+				Args: graphql.FieldConfigArgument{
+					"suburb":      &graphql.ArgumentConfig{Type: graphql.String},
+					"minBedrooms": &graphql.ArgumentConfig{Type: graphql.Float},
+					"maxPrice":    &graphql.ArgumentConfig{Type: graphql.Float},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					suburb, _ := p.Args["suburb"].(string)
+					minBedrooms, _ := p.Args["minBedrooms"].(float64)
+					maxPrice, _ := p.Args["maxPrice"].(float64)
+					current, _, _ := dc.seen.current(string(p.Source.(graphqlSearch)))
+					var listings []graphqlListing
+					for _, l := range current {
+						if suburb != "" && !strings.EqualFold(l.Suburb, suburb) ||
+							float64(l.Bedrooms) < minBedrooms ||
+							maxPrice > 0 && (l.Price == 0 || l.Price > maxPrice) {
+							continue
+						}
+						firstSeen := l.FirstSeen
+						listings = append(listings, graphqlListing{l.listingSummary, &firstSeen})
+					}
+					return listings, nil
+				},
+			},
+			"events": &graphql.Field{
+				Type:        graphql.NewList(eventType),
+				Description: "Recent changes to the search's listings, oldest first.",
+				Args:        eventsArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return events(string(p.Source.(graphqlSearch)), p)
+				},
+			},
+			"history": &graphql.Field{
+				Type:        graphql.NewList(historyPointType),
+				Description: "The number and median price of listings each time the search ran, from the history database.",
+				Args: graphql.FieldConfigArgument{
+					"from": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.DateTime)},
+					"to":   &graphql.ArgumentConfig{Type: graphql.DateTime},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if dc.history == nil {
+						return nil, errors.New("history needs --history.db")
+					}
+					from, _ := p.Args["from"].(time.Time)
+					to, ok := p.Args["to"].(time.Time)
+					if !ok {
+						to = time.Now()
+					}
+					snapshots, err := dc.history.snapshots(p.Context, string(p.Source.(graphqlSearch)), from, to)
+					if err != nil {
+						return nil, errors.New(secrets.redact(err.Error()))
+					}
+					return summarizeSnapshots(snapshots), nil
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"modules": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "The names of the searches in --searches_dir.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return dc.searches.names(), nil
+				},
+			},
+			"searches": &graphql.Field{
+				Type:        graphql.NewList(searchType),
+				Description: "The searches that have run since the exporter started.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var searches []graphqlSearch
+					for _, k := range dc.seen.keys() {
+						searches = append(searches, graphqlSearch(k))
+					}
+					return searches, nil
+				},
+			},
+			"search": &graphql.Field{
+				Type:        searchType,
+				Description: `A search, by its key, like "module:pyrmont_rent", or by module name.`,
+				Args: graphql.FieldConfigArgument{
+					"key":    &graphql.ArgumentConfig{Type: graphql.String},
+					"module": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if module, _ := p.Args["module"].(string); module != "" {
+						return graphqlSearch("module:" + module), nil
+					}
+					if key, _ := p.Args["key"].(string); key != "" {
+						return graphqlSearch(key), nil
+					}
+					return nil, errors.New("search needs a key or module")
+				},
+			},
+			"events": &graphql.Field{
+				Type:        graphql.NewList(eventType),
+				Description: "Recent changes to the listings of every search, oldest first.",
+				Args:        eventsArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return events("", p)
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/mhansen/domain_exporter/pkg/listingspb"
+	"github.com/prometheus/exporter-toolkit/web"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v2"
+)
+
+// grpcServer serves the Listings gRPC API.
+type grpcServer struct {
+	listingspb.UnimplementedListingsServer
+	dc domainCollector
+}
+
+// newGRPCServer returns a gRPC server for the Listings API, serving TLS if
+// tlsConfig isn't nil, and only to clients whose certificates have one of
+// clientNames if any are given, like --web.client-allowed-names.
+func newGRPCServer(dc domainCollector, tlsConfig *tls.Config, clientNames []string) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if len(clientNames) > 0 {
+		allowed := newAllowedNames(clientNames)
+		check := func(ctx context.Context) error {
+			var state *tls.ConnectionState
+			addr := ""
+			if p, ok := peer.FromContext(ctx); ok {
+				addr = p.Addr.String()
+				if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+					state = &info.State
+				}
+			}
+			if err := allowed.check(state, addr); err != nil {
+				return status.Error(codes.PermissionDenied, err.Error())
+			}
+			return nil
+		}
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+				if err := check(ctx); err != nil {
+					return nil, err
+				}
+				return h(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+				if err := check(ss.Context()); err != nil {
+					return err
+				}
+				return h(srv, ss)
+			}))
+	}
+	s := grpc.NewServer(opts...)
+	listingspb.RegisterListingsServer(s, grpcServer{dc: dc})
+	return s
+}
+
+// grpcTLSConfig returns the TLS settings in the web config file at path, or
+// nil if it has none. Like the HTTP listeners, certificates are read again on
+// each handshake, so renewing them needs no restart.
+func grpcTLSConfig(path string) (*tls.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// The same defaults as exporter-toolkit.
+	c := web.Config{TLSConfig: web.TLSConfig{
+		MinVersion:               tls.VersionTLS12,
+		MaxVersion:               tls.VersionTLS13,
+		PreferServerCipherSuites: true,
+	}}
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return nil, err
+	}
+	if c.TLSConfig.TLSCert == "" && c.TLSConfig.TLSCertPath == "" {
+		return nil, nil
+	}
+	c.TLSConfig.SetDirectory(filepath.Dir(path))
+	return web.ConfigToTLSConfig(&c.TLSConfig)
+}
+
+// isLoopback reports whether addr, as passed to net.Listen, only listens on
+// a loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Query implements listingspb.ListingsServer.
+func (s grpcServer) Query(ctx context.Context, req *listingspb.QueryRequest) (*listingspb.QueryResponse, error) {
+	ctx = withTrigger(ctx, listingspb.Listings_Query_FullMethodName)
+	// Queries search, so need the same auth as /listings, in the
+	// authorization metadata, and are rate limited the same.
+	var authorization, tenantName string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+		if s.dc.tenants != nil {
+			if v := md.Get(s.dc.tenants.header); len(v) > 0 {
+				tenantName = v[0]
+			}
+		}
+	}
+	if !s.dc.auth.check(authorization) {
+		return nil, status.Error(codes.Unauthenticated, "queries need a bearer token or basic auth")
+	}
+	if s.dc.health.quiescing() {
+		return nil, status.Error(codes.Unavailable, "the exporter is quiescing, and not running searches")
+	}
+	if tenantName != "" {
+		if err := s.dc.tenants.choose(tenantName, authorization, "metadata"); err != nil {
+			s.dc.badRequests.WithLabelValues(err.Reason).Inc()
+			code := codes.InvalidArgument
+			if err.code == http.StatusForbidden {
+				code = codes.PermissionDenied
+			}
+			return nil, status.Error(code, err.Message)
+		}
+		ctx = withTenant(ctx, tenantName)
+	}
+	if !s.dc.limits.allowGRPC(ctx) {
+		return nil, status.Error(codes.ResourceExhausted, "too many searches, slow down")
+	}
+	// Go through the same parameters as the HTTP endpoints, so searches
+	// behave the same and share their history.
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"module":      req.Module,
+		"state":       req.State,
+		"suburb":      req.Suburb,
+		"postCode":    req.PostCode,
+		"listingType": req.ListingType,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if req.MinBedrooms != nil {
+		q.Set("minBedrooms", strconv.FormatFloat(float64(*req.MinBedrooms), 'f', -1, 32))
+	}
+	if req.MaxBedrooms != nil {
+		q.Set("maxBedrooms", strconv.FormatFloat(float64(*req.MaxBedrooms), 'f', -1, 32))
+	}
+	module, rsr, err := s.dc.searchFromQuery(q)
+	if err != nil {
+		pe := s.dc.rejected(err)
+		if pe.code == http.StatusForbidden {
+			return nil, status.Error(codes.PermissionDenied, pe.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, pe.Error())
+	}
+	listings, err := s.dc.search(ctx, module, rsr)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, fmt.Sprintf("error searching domain: %v", err))
+	}
+	resp := &listingspb.QueryResponse{
+		Search: searchKey(module, rsr),
+		Total:  int32(len(listings)),
+	}
+	for _, g := range collector.GroupListings(listings) {
+		resp.Groups = append(resp.Groups, &listingspb.ListingGroup{
+			PropertyType: g.Labels[0],
+			Suburb:       g.Labels[1],
+			Postcode:     g.Labels[2],
+			Bedrooms:     g.Labels[3],
+			Bathrooms:    g.Labels[4],
+			Carspaces:    g.Labels[5],
+			Count:        g.Count,
+			MedianPrice:  g.MedianPrice,
+		})
+	}
+	if req.IncludeListings {
+		for _, l := range listings {
+			resp.Listings = append(resp.Listings, listingProto(summarize(l)))
+		}
+	}
+	return resp, nil
+}
+
+// WatchEvents implements listingspb.ListingsServer.
+func (s grpcServer) WatchEvents(req *listingspb.WatchEventsRequest, stream listingspb.Listings_WatchEventsServer) error {
+	filter, err := newWatchFilter(req.Types, req.Searches)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	events, stop := s.dc.hub.watch()
+	defer stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if !filter.match(e) {
+				continue
+			}
+			err := stream.Send(&listingspb.ListingEvent{
+				Type:          e.Type,
+				Search:        e.Search,
+				Time:          timestamppb.New(e.Time),
+				Listing:       listingProto(e.Listing),
+				Price:         e.Price,
+				PreviousPrice: e.PreviousPrice,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func listingProto(l listingSummary) *listingspb.Listing {
+	return &listingspb.Listing{
+		Id:           l.ID,
+		Headline:     l.Headline,
+		Address:      l.Address,
+		Suburb:       l.Suburb,
+		State:        l.State,
+		Postcode:     l.Postcode,
+		PropertyType: l.PropertyType,
+		Bedrooms:     l.Bedrooms,
+		Bathrooms:    l.Bathrooms,
+		Carspaces:    l.Carspaces,
+		DisplayPrice: l.DisplayPrice,
+		Price:        l.Price,
+		DateListed:   l.DateListed,
+		Latitude:     l.Latitude,
+		Longitude:    l.Longitude,
+		Url:          l.URL,
+	}
+}
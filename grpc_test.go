@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:10551": true,
+		"127.0.0.1:10551": true,
+		"127.0.0.2:10551": true,
+		"[::1]:10551":     true,
+		":10551":          false,
+		"0.0.0.0:10551":   false,
+		"[::]:10551":      false,
+		"10.0.0.1:10551":  false,
+		"example.com:80":  false,
+		"localhost":       false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// writeCert writes a self-signed certificate and its key to dir as
+// server.crt and server.key.
+func writeCert(t *testing.T, dir string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, block := range map[string]*pem.Block{
+		"server.crt": {Type: "CERTIFICATE", Bytes: der},
+		"server.key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGRPCTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir)
+	for _, tc := range []struct {
+		name, contents string
+		wantTLS        bool
+		wantErr        bool
+	}{
+		{name: "basic auth only", contents: "basic_auth_users:\n  alice: $2y$10$abc\n"},
+		{name: "empty", contents: ""},
+		{name: "typo", contents: "tls_server_confg: {}\n", wantErr: true},
+		// Relative to the web config file, like exporter-toolkit.
+		{name: "tls", contents: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS13\n", wantTLS: true},
+		{name: "missing cert", contents: "tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".yml")
+			if err := os.WriteFile(path, []byte(tc.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := grpcTLSConfig(path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("grpcTLSConfig() = %v, want error %v", err, tc.wantErr)
+			}
+			if (cfg != nil) != tc.wantTLS {
+				t.Errorf("grpcTLSConfig() = %v, want TLS %v", cfg, tc.wantTLS)
+			}
+			if cfg != nil && cfg.MinVersion != tls.VersionTLS13 {
+				t.Errorf("MinVersion = %x, want TLS 1.3 from the file", cfg.MinVersion)
+			}
+		})
+	}
+	if cfg, err := grpcTLSConfig(""); cfg != nil || err != nil {
+		t.Errorf(`grpcTLSConfig("") = %v, %v, want nil, nil`, cfg, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health tracks the outcome of Domain API calls, for readiness checks.
+type health struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	quiesced    bool
+	// checkErr is the error from the last --web.ready-api-check call.
+	checkErr error
+	calls    callWindow
+}
+
+// quiesce makes the exporter not ready, and stop searching, ahead of being
+// shut down.
+func (h *health) quiesce() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.quiesced = true
+}
+
+func (h *health) quiescing() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quiesced
+}
+
+// apiFailed reports whether a Domain API call failed in a way that says the
+// API or our key isn't working. Other errors, like 400s from bad searches,
+// say more about the search than the API, and clients can cause them.
+func apiFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+func (h *health) record(ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if ok {
+		h.lastSuccess = now
+	} else {
+		h.lastFailure = now
+	}
+	h.calls.add(now, ok)
+}
+
+// sinceSuccess returns how long it's been since a Domain API call succeeded.
+func (h *health) sinceSuccess() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Since(h.lastSuccess)
+}
+
+// checkAPIEvery makes sure a Domain API call succeeds every interval, for
+// readyzHandler, checking the API key whenever searches haven't already done
+// so in the last half of it. So an exporter that's scraped often makes no
+// extra calls, and an idle one makes fewer than two an interval.
+func (dc domainCollector) checkAPIEvery(ctx context.Context, interval time.Duration) {
+	ctx = withTrigger(ctx, "--web.ready-api-check")
+	// Looking more often than checking, so a check is never late enough for
+	// readyzHandler to report not ready in between.
+	t := time.NewTicker(interval / 4)
+	defer t.Stop()
+	for !dc.health.quiescing() {
+		if dc.health.sinceSuccess() >= interval/2 {
+			cctx, cancel := context.WithTimeout(ctx, interval/2)
+			err := dc.checkAPI(cctx)
+			cancel()
+			if err != nil {
+				slog.Warn("Domain API check failed, reporting not ready", "err", err)
+			}
+			dc.health.mu.Lock()
+			dc.health.checkErr = err
+			dc.health.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// healthzHandler reports that the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether the exporter should be sent scrapes. Config
+// is validated before we start serving, so we're ready unless we're
+// quiescing, maxAge is set and the last Domain API call failed with no
+// success within maxAge, or apiCheck is set and no call has succeeded within
+// it, which checkAPIEvery makes sure of while the API key works.
+func (h *health) readyzHandler(maxAge, apiCheck time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		lastSuccess, lastFailure, quiesced, checkErr := h.lastSuccess, h.lastFailure, h.quiesced, h.checkErr
+		h.mu.Unlock()
+		if quiesced {
+			http.Error(w, "quiescing", http.StatusServiceUnavailable)
+			return
+		}
+		if maxAge > 0 && lastFailure.After(lastSuccess) && time.Since(lastSuccess) > maxAge {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if lastSuccess.IsZero() {
+				fmt.Fprintf(w, "no successful Domain API calls, last failure at %v\n", lastFailure.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, "no successful Domain API calls since %v, last failure at %v\n", lastSuccess.Format(time.RFC3339), lastFailure.Format(time.RFC3339))
+			}
+			return
+		}
+		if apiCheck > 0 && time.Since(lastSuccess) > apiCheck {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			switch {
+			case checkErr != nil:
+				fmt.Fprintf(w, "Domain API check failed: %v\n", checkErr)
+			case lastSuccess.IsZero():
+				fmt.Fprintln(w, "Domain API not checked yet")
+			default:
+				fmt.Fprintf(w, "no successful Domain API calls since %v\n", lastSuccess.Format(time.RFC3339))
+			}
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
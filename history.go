@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	_ "modernc.org/sqlite"
+)
+
+// historySchema creates the table of listing snapshots. It works in both
+// SQLite and Postgres.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS listing_snapshots (
+	observed_at   TIMESTAMP NOT NULL,
+	search        TEXT NOT NULL,
+	listing_id    BIGINT NOT NULL,
+	address       TEXT NOT NULL,
+	suburb        TEXT NOT NULL,
+	state         TEXT NOT NULL,
+	postcode      TEXT NOT NULL,
+	property_type TEXT NOT NULL,
+	bedrooms      REAL NOT NULL,
+	bathrooms     REAL NOT NULL,
+	carspaces     INTEGER NOT NULL,
+	display_price TEXT NOT NULL,
+	price         REAL NOT NULL,
+	date_listed   TEXT NOT NULL,
+	url           TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS listing_snapshots_listing ON listing_snapshots (listing_id, observed_at);
+CREATE INDEX IF NOT EXISTS listing_snapshots_search ON listing_snapshots (search, observed_at);
+`
+
+// historyStore records every listing each search finds in a database, for
+// history longer than Prometheus keeps.
+type historyStore struct {
+	db     *sql.DB
+	driver string
+	writes *prometheus.CounterVec
+}
+
+// openHistory opens the database at dsn, which is either
+// sqlite://<path> or a postgres:// URL, and creates the schema if needed.
+func openHistory(dsn string) (*historyStore, error) {
+	var driver, source string
+	switch {
+	case strings.HasPrefix(dsn, "sqlite://"):
+		driver, source = "sqlite", strings.TrimPrefix(dsn, "sqlite://")
+		// Store times in a format SQLite's date functions understand.
+		sep := "?"
+		if strings.Contains(source, "?") {
+			sep = "&"
+		}
+		source += sep + "_time_format=sqlite"
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		driver, source = "pgx", dsn
+	default:
+		return nil, fmt.Errorf("unsupported database %q, want sqlite://<path> or postgres://...", dsn)
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite only allows one writer at a time.
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range strings.Split(historySchema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("couldn't create schema: %v", err)
+		}
+	}
+	h := &historyStore{
+		db:     db,
+		driver: driver,
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_history_writes_total",
+			Help: "Number of search results written to the history database, by whether they were written.",
+		}, []string{"result"}),
+	}
+	h.writes.WithLabelValues("success")
+	h.writes.WithLabelValues("failure")
+	return h, nil
+}
+
+// placeholder returns the nth (from 1) query parameter placeholder.
+func (h *historyStore) placeholder(n int) string {
+	if h.driver == "pgx" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// insertQuery returns an INSERT statement for a listing snapshot.
+func (h *historyStore) insertQuery() string {
+	cols := []string{"observed_at", "search", "listing_id", "address", "suburb", "state", "postcode", "property_type", "bedrooms", "bathrooms", "carspaces", "display_price", "price", "date_listed", "url"}
+	ps := make([]string, len(cols))
+	for i := range cols {
+		ps[i] = h.placeholder(i + 1)
+	}
+	return fmt.Sprintf("INSERT INTO listing_snapshots (%s) VALUES (%s)", strings.Join(cols, ", "), strings.Join(ps, ", "))
+}
+
+// record writes a snapshot of every listing a search found.
+func (h *historyStore) record(ctx context.Context, search string, observed time.Time, listings []listingSummary) error {
+	if h == nil {
+		return nil
+	}
+	err := h.insert(ctx, search, observed, listings)
+	if err != nil {
+		h.writes.WithLabelValues("failure").Inc()
+		return err
+	}
+	h.writes.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (h *historyStore) insert(ctx context.Context, search string, observed time.Time, listings []listingSummary) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, h.insertQuery())
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, l := range listings {
+		if _, err := stmt.ExecContext(ctx, observed.UTC(), search, l.ID, l.Address, l.Suburb, l.State, l.Postcode,
+			l.PropertyType, l.Bedrooms, l.Bathrooms, l.Carspaces, l.DisplayPrice, l.Price, l.DateListed, l.URL); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// listingSnapshot is a listing as seen by a search at a time.
+type listingSnapshot struct {
+	ObservedAt time.Time `json:"observedAt"`
+	Search     string    `json:"search"`
+	listingSummary
+}
+
+// searches returns the searches with history, sorted.
+func (h *historyStore) searches(ctx context.Context) ([]string, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT DISTINCT search FROM listing_snapshots ORDER BY search")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var searches []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// snapshotColumns are the columns scanned by scanSnapshots.
+const snapshotColumns = `observed_at, search, listing_id, address, suburb, state, postcode, property_type,
+	bedrooms, bathrooms, carspaces, display_price, price, date_listed, url`
+
+// snapshots returns the listings a search found between from and to, oldest
+// first.
+func (h *historyStore) snapshots(ctx context.Context, search string, from, to time.Time) ([]listingSnapshot, error) {
+	q := fmt.Sprintf(`SELECT %s
+FROM listing_snapshots
+WHERE search = %s AND observed_at >= %s AND observed_at <= %s
+ORDER BY observed_at, listing_id`, snapshotColumns, h.placeholder(1), h.placeholder(2), h.placeholder(3))
+	rows, err := h.db.QueryContext(ctx, q, search, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []listingSnapshot
+	err = scanSnapshots(rows, func(s listingSnapshot) error {
+		snapshots = append(snapshots, s)
+		return nil
+	})
+	return snapshots, err
+}
+
+// scanSnapshots calls fn with each row, of snapshotColumns, and closes rows.
+func scanSnapshots(rows *sql.Rows, fn func(listingSnapshot) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		var s listingSnapshot
+		if err := rows.Scan(&s.ObservedAt, &s.Search, &s.ID, &s.Address, &s.Suburb, &s.State, &s.Postcode, &s.PropertyType,
+			&s.Bedrooms, &s.Bathrooms, &s.Carspaces, &s.DisplayPrice, &s.Price, &s.DateListed, &s.URL); err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// historyPoint summarises the listings a search found at one time.
+type historyPoint struct {
+	Time        time.Time
+	Count       int
+	MedianPrice float64
+}
+
+// summarizeSnapshots returns a historyPoint for each time in snapshots, which
+// must be sorted by time.
+func summarizeSnapshots(snapshots []listingSnapshot) []historyPoint {
+	var points []historyPoint
+	var prices []float64
+	for i, s := range snapshots {
+		if i == 0 || !s.ObservedAt.Equal(snapshots[i-1].ObservedAt) {
+			if len(points) > 0 {
+				points[len(points)-1].MedianPrice = collector.Median(prices)
+			}
+			points = append(points, historyPoint{Time: s.ObservedAt})
+			prices = nil
+		}
+		points[len(points)-1].Count++
+		if s.Price != 0 {
+			prices = append(prices, s.Price)
+		}
+	}
+	if len(points) > 0 {
+		points[len(points)-1].MedianPrice = collector.Median(prices)
+	}
+	return points
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+)
+
+// haDevice groups the exporter's sensors into one Home Assistant device.
+var haDevice = map[string]interface{}{
+	"identifiers":  []string{"domain_exporter"},
+	"name":         "Domain exporter",
+	"manufacturer": "domain_exporter",
+	"sw_version":   version,
+}
+
+// haDiscovery publishes Home Assistant MQTT discovery messages and states for
+// sensors of each suburb's listing count and median price, and of the prices
+// of watched listings, so they turn up in Home Assistant without configuring
+// it. See https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery.
+type haDiscovery struct {
+	client mqtt.Client
+	prefix string
+	// watch are the IDs of the listings to have price sensors for.
+	watch map[int32]bool
+
+	mu         sync.Mutex
+	configured map[string]bool // By unique ID.
+}
+
+func newHADiscovery(client mqtt.Client, prefix string, watch []int32) *haDiscovery {
+	d := &haDiscovery{client: client, prefix: prefix, watch: map[int32]bool{}, configured: map[string]bool{}}
+	for _, id := range watch {
+		d.watch[id] = true
+	}
+	return d
+}
+
+// haSensor is a Home Assistant MQTT sensor discovery config.
+type haSensor struct {
+	Name                string                 `json:"name"`
+	UniqueID            string                 `json:"unique_id"`
+	StateTopic          string                 `json:"state_topic"`
+	ValueTemplate       string                 `json:"value_template"`
+	JSONAttributesTopic string                 `json:"json_attributes_topic,omitempty"`
+	UnitOfMeasurement   string                 `json:"unit_of_measurement,omitempty"`
+	StateClass          string                 `json:"state_class,omitempty"`
+	Icon                string                 `json:"icon,omitempty"`
+	Device              map[string]interface{} `json:"device"`
+}
+
+// haObjectID makes s safe to use in a discovery topic or unique ID.
+func haObjectID(s string) string {
+	return strings.ToLower(strings.Trim(unsafePathChars.ReplaceAllString(s, "_"), "_"))
+}
+
+// publish updates the sensors for a search that just found listings, in the
+// background, first configuring any sensors Home Assistant hasn't been told
+// about.
+func (d *haDiscovery) publish(search string, listings []listingSummary) {
+	if d == nil {
+		return
+	}
+	type suburbState struct {
+		Count       int     `json:"count"`
+		MedianPrice float64 `json:"median_price,omitempty"`
+		prices      []float64
+	}
+	suburbs := map[string]*suburbState{}
+	var msgs []mqttMessage
+	for _, l := range listings {
+		s, ok := suburbs[l.Suburb]
+		if !ok {
+			s = &suburbState{}
+			suburbs[l.Suburb] = s
+		}
+		s.Count++
+		if l.Price != 0 {
+			s.prices = append(s.prices, l.Price)
+		}
+		if !d.watch[l.ID] {
+			continue
+		}
+		id := strconv.Itoa(int(l.ID))
+		topic := "domain_exporter/listings/" + id
+		msgs = append(msgs, d.configure(haSensor{
+			Name:                l.Address + " price",
+			UniqueID:            "domain_exporter_listing_" + id,
+			StateTopic:          topic,
+			ValueTemplate:       "{{ value_json.price | default(none) }}",
+			JSONAttributesTopic: topic,
+			Icon:                "mdi:home-search",
+		})...)
+		msgs = append(msgs, mqttJSON(topic, l))
+	}
+	for name, s := range suburbs {
+		s.MedianPrice = collector.Median(s.prices)
+		object := haObjectID(search + "_" + name)
+		topic := "domain_exporter/" + search + "/suburbs/" + haObjectID(name)
+		msgs = append(msgs, d.configure(haSensor{
+			Name:          fmt.Sprintf("%s listings (%s)", name, search),
+			UniqueID:      "domain_exporter_" + object + "_count",
+			StateTopic:    topic,
+			ValueTemplate: "{{ value_json.count }}",
+			StateClass:    "measurement",
+			Icon:          "mdi:home-group",
+		})...)
+		msgs = append(msgs, d.configure(haSensor{
+			Name:          fmt.Sprintf("%s median price (%s)", name, search),
+			UniqueID:      "domain_exporter_" + object + "_median_price",
+			StateTopic:    topic,
+			ValueTemplate: "{{ value_json.median_price | default(none) }}",
+			StateClass:    "measurement",
+			Icon:          "mdi:currency-usd",
+		})...)
+		msgs = append(msgs, mqttJSON(topic, s))
+	}
+	go func() {
+		for _, m := range msgs {
+			t := d.client.Publish(m.topic, 1, true, m.payload)
+			if !t.WaitTimeout(mqttTimeout) {
+				slog.Error("timed out publishing Home Assistant sensor", "topic", m.topic)
+				return
+			}
+			if err := t.Error(); err != nil {
+				slog.Error("couldn't publish Home Assistant sensor", "topic", m.topic, "err", err)
+				return
+			}
+		}
+	}()
+}
+
+// configure returns the discovery message for a sensor, if it hasn't been
+// sent yet.
+func (d *haDiscovery) configure(s haSensor) []mqttMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.configured[s.UniqueID] {
+		return nil
+	}
+	d.configured[s.UniqueID] = true
+	s.Device = haDevice
+	return []mqttMessage{mqttJSON(fmt.Sprintf("%s/sensor/%s/config", d.prefix, s.UniqueID), s)}
+}
+
+// mqttMessage is a message to publish.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+func mqttJSON(topic string, v interface{}) mqttMessage {
+	// Everything passed in marshals.
+	b, _ := json.Marshal(v)
+	return mqttMessage{topic, b}
+}
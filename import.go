@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/parquet-go/parquet-go"
+)
+
+// readSnapshotFile reads the listings in a file written by the export
+// command, /export/csv, /api/v1/listings?listings=true or --parquet.dest, by
+// its extension. Parquet files say which search found their listings and
+// when; CSV and JSON files don't, so they're from search, or the module in a
+// JSON file, at observed.
+func readSnapshotFile(path, search string, observed time.Time) ([]listingSnapshot, error) {
+	if strings.EqualFold(filepath.Ext(path), ".parquet") {
+		rows, err := parquet.ReadFile[parquetRow](path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots := make([]listingSnapshot, len(rows))
+		for i, r := range rows {
+			snapshots[i] = listingSnapshot{
+				ObservedAt: r.ObservedAt,
+				Search:     r.Search,
+				listingSummary: listingSummary{
+					ID:           int32(r.ListingID),
+					Address:      r.Address,
+					Suburb:       r.Suburb,
+					State:        r.State,
+					Postcode:     r.Postcode,
+					PropertyType: r.PropertyType,
+					Bedrooms:     r.Bedrooms,
+					Bathrooms:    r.Bathrooms,
+					Carspaces:    r.Carspaces,
+					DisplayPrice: r.DisplayPrice,
+					Price:        r.Price,
+					DateListed:   r.DateListed,
+					URL:          r.URL,
+				},
+			}
+		}
+		return snapshots, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var listings []listingSummary
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		if listings, err = readCSVListings(f); err != nil {
+			return nil, err
+		}
+	case ".json":
+		var resp apiListingsResponse
+		if err := json.NewDecoder(f).Decode(&resp); err != nil {
+			return nil, err
+		}
+		if resp.Total > 0 && len(resp.Listings) == 0 {
+			return nil, fmt.Errorf("no listings, export it with --format=json or ?listings=true")
+		}
+		if resp.Module != "" && search == "" {
+			search = "module:" + resp.Module
+		}
+		listings = resp.Listings
+	default:
+		return nil, fmt.Errorf("unknown file type %q, want .csv, .json or .parquet", ext)
+	}
+	if search == "" {
+		return nil, fmt.Errorf("don't know which search found the listings, pass --module")
+	}
+	snapshots := make([]listingSnapshot, len(listings))
+	for i, l := range listings {
+		snapshots[i] = listingSnapshot{ObservedAt: observed, Search: search, listingSummary: l}
+	}
+	return snapshots, nil
+}
+
+// readCSVListings reads listings written by writeCSV.
+func readCSVListings(r io.Reader) ([]listingSummary, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[h] = i
+	}
+	if _, ok := cols["id"]; !ok {
+		return nil, fmt.Errorf("no id column")
+	}
+	var listings []listingSummary
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return listings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(col string) string {
+			if i, ok := cols[col]; ok {
+				return rec[i]
+			}
+			return ""
+		}
+		id, err := strconv.ParseInt(get("id"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad id %q", get("id"))
+		}
+		l := listingSummary{
+			ID:           int32(id),
+			Address:      get("address"),
+			Suburb:       get("suburb"),
+			DisplayPrice: get("price"),
+			Price:        collector.ParsePrice(domain.PriceDetails{DisplayPrice: get("price")}),
+			DateListed:   get("dateListed"),
+			URL:          get("url"),
+		}
+		for col, field := range map[string]*float32{"bedrooms": &l.Bedrooms, "bathrooms": &l.Bathrooms} {
+			if v := get(col); v != "" {
+				f, err := strconv.ParseFloat(v, 32)
+				if err != nil {
+					return nil, fmt.Errorf("bad %s %q", col, v)
+				}
+				*field = float32(f)
+			}
+		}
+		listings = append(listings, l)
+	}
+}
+
+// runImport merges the listings in files into the history database, skipping
+// searches' results already in it. module is the search that found the
+// listings in CSV and JSON files, and observedAt when, in RFC 3339, defaulting
+// to each file's modification time.
+func runImport(ctx context.Context, h *historyStore, files []string, module, observedAt string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no files to import")
+	}
+	var search string
+	if module != "" {
+		search = "module:" + module
+	}
+	var at time.Time
+	if observedAt != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, observedAt); err != nil {
+			return fmt.Errorf("bad --observed-at %q, want a time like 2024-01-31T09:00:00+11:00", observedAt)
+		}
+	}
+	m := h.merger()
+	for _, path := range files {
+		observed := at
+		if observed.IsZero() {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			observed = fi.ModTime()
+		}
+		snapshots, err := readSnapshotFile(path, search, observed)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s: %v", path, err)
+		}
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			a, b := snapshots[i], snapshots[j]
+			if !a.ObservedAt.Equal(b.ObservedAt) {
+				return a.ObservedAt.Before(b.ObservedAt)
+			}
+			return a.Search < b.Search
+		})
+		before := m.merged + m.skipped
+		for _, s := range snapshots {
+			if err := m.add(ctx, s); err != nil {
+				return err
+			}
+		}
+		if err := m.flush(ctx); err != nil {
+			return err
+		}
+		slog.Info("Imported", "file", path, "snapshots", m.merged+m.skipped-before)
+	}
+	slog.Info("Imported history", "imported", m.merged, "skipped", m.skipped)
+	return nil
+}
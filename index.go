@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/mhansen/domain_exporter/pkg/collector"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!doctype html>
+<title>Domain Exporter</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; }
+</style>
+<h1>Domain Exporter</h1>
+{{- if not .TelemetryElsewhere}}
+<a href="/metrics">Metrics</a> · <a href="/debug/listings">Listings</a>
+{{- end}}
+{{- with .Modules}}
+<h2>Modules</h2>
+<ul>
+{{range .}}<li><a href="/listings?module={{.}}">{{.}}</a></li>
+{{end -}}
+</ul>
+{{- end}}
+{{- if not .ReadOnly}}
+<h2>Search</h2>
+<form>
+<label>State <select name="state">
+{{- range .States}}<option{{if eq . ($.Query.Get "state")}} selected{{end}}>{{.}}</option>{{end -}}
+</select></label>
+<label>Suburb <input name="suburb" value="{{.Query.Get "suburb"}}"></label>
+<label>Postcode <input name="postCode" size="4" value="{{.Query.Get "postCode"}}"></label>
+<label>Min beds <input name="minBedrooms" type="number" min="0" size="2" value="{{.Query.Get "minBedrooms"}}"></label>
+<label>Max beds <input name="maxBedrooms" type="number" min="0" size="2" value="{{.Query.Get "maxBedrooms"}}"></label>
+<label>Type <select name="listingType">
+{{- range .ListingTypes}}<option{{if eq . ($.Query.Get "listingType")}} selected{{end}}>{{.}}</option>{{end -}}
+</select></label>
+<button>Preview</button>
+</form>
+<p>Previewing runs the search, which counts towards the API quota.</p>
+{{- end}}
+{{- with .ListingsURL}}
+<p>Scrape <a href="{{.}}">{{.}}</a></p>
+{{- end}}
+{{- with .Error}}
+<p>{{.}}</p>
+{{- end}}
+{{- if .Previewed}}
+<p>{{.Total}} listings.</p>
+<table>
+<tr><th>Type</th><th>Suburb</th><th>Postcode</th><th>Beds</th><th>Baths</th><th>Cars</th><th>Count</th><th>Median price</th></tr>
+{{range .Groups -}}
+<tr>{{range .Labels}}<td>{{.}}</td>{{end}}<td>{{.Count}}</td><td>{{if .MedianPrice}}{{.MedianPrice}}{{end}}</td></tr>
+{{end -}}
+</table>
+{{- end}}
+`))
+
+// indexHandler serves the landing page, with a form that builds a /listings
+// URL for an ad-hoc search and previews what it finds, unless ad-hoc searches
+// are turned off by --web.read-only. It's served for every path nothing else
+// is, but only previews at /, through searching, like /listings.
+func (dc domainCollector) indexHandler(searching func(http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
+	preview := searching(func(w http.ResponseWriter, r *http.Request) {
+		dc.serveIndex(w, r, true)
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path == "/" && !dc.readOnly && (q.Get("suburb") != "" || q.Get("postCode") != "") {
+			preview(w, r)
+			return
+		}
+		dc.serveIndex(w, r, false)
+	}
+}
+
+// serveIndex renders the landing page, searching for the preview if asked
+// to.
+func (dc domainCollector) serveIndex(w http.ResponseWriter, r *http.Request, preview bool) {
+	q := r.URL.Query()
+	data := struct {
+		Modules  []string
+		ReadOnly bool
+		// TelemetryElsewhere is whether /metrics and /debug are on
+		// --web.telemetry-listen.
+		TelemetryElsewhere bool
+		States             []string
+		ListingTypes       []string
+		Query              url.Values
+		ListingsURL        string
+		Error              string
+		Previewed          bool
+		Total              int
+		Groups             []collector.Group
+	}{
+		Modules:            dc.searches.names(),
+		ReadOnly:           dc.readOnly,
+		TelemetryElsewhere: *telemetryAddr != "",
+		States:             states,
+		ListingTypes:       listingTypes,
+		Query:              q,
+	}
+	if preview {
+		params := url.Values{}
+		for k := range q {
+			if v := q.Get(k); v != "" && !(k == "listingType" && v == "Rent") {
+				params.Set(k, v)
+			}
+		}
+		data.ListingsURL = "/listings?" + params.Encode()
+		module, rsr, err := dc.searchFromQuery(params)
+		if err != nil {
+			data.Error = dc.rejected(err).Error()
+		} else if listings, err := dc.search(r.Context(), module, rsr); err != nil {
+			data.Error = fmt.Sprintf("error searching domain: %v", err)
+		} else {
+			data.Previewed = true
+			data.Total = len(listings)
+			data.Groups = collector.GroupListings(listings)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		slog.Error("couldn't render index", "err", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIndexHandlerPreviews(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		url         string
+		readOnly    bool
+		wantPreview bool
+	}{
+		{"landing page", "/", false, false},
+		{"preview", "/?state=NSW&suburb=Pyrmont", false, true},
+		{"postcode preview", "/?postCode=2009", false, true},
+		{"other path", "/anything?state=NSW&suburb=Pyrmont", false, false},
+		{"read only", "/?state=NSW&suburb=Pyrmont", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			previewed := false
+			// Stands in for the auth, quiescing and rate limiting of
+			// endpoints that search, turning the search away.
+			searching := func(h http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					previewed = true
+					http.Error(w, "quiescing", http.StatusServiceUnavailable)
+				}
+			}
+			dc := domainCollector{searches: &searchSet{}, readOnly: tc.readOnly}
+			w := httptest.NewRecorder()
+			dc.indexHandler(searching)(w, httptest.NewRequest("GET", tc.url, nil))
+			if previewed != tc.wantPreview {
+				t.Errorf("previewed = %v, want %v", previewed, tc.wantPreview)
+			}
+			if !tc.wantPreview && w.Code != http.StatusOK {
+				t.Errorf("got %d, want the landing page", w.Code)
+			}
+		})
+	}
+}
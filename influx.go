@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// influxWriter pushes metrics to InfluxDB in line protocol, using the v2 API
+// if it has an org or token, and the v1 API otherwise. Each metric is a
+// measurement with its labels as tags and a single "value" field.
+type influxWriter struct {
+	url    *url.URL
+	db     string
+	org    string
+	token  string
+	client *http.Client
+}
+
+func newInfluxWriter(u, db, org, token string) (*influxWriter, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	return &influxWriter{url: pu, db: db, org: org, token: token, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Push implements pusher.
+func (iw *influxWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	samples, err := gatherSamples(g, map[string]string{"module": module})
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for _, s := range samples {
+		b.WriteString(influxMeasurementEscaper.Replace(s.Name))
+		for _, l := range s.Labels {
+			// Line protocol has no way to write empty tag values.
+			if l.Value == "" {
+				continue
+			}
+			fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(l.Name), influxTagEscaper.Replace(l.Value))
+		}
+		fmt.Fprintf(&b, " value=%s %s\n", strconv.FormatFloat(s.Value, 'g', -1, 64), ts)
+	}
+
+	u := *iw.url
+	q := url.Values{"precision": {"ms"}}
+	if iw.org != "" || iw.token != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		q.Set("org", iw.org)
+		q.Set("bucket", iw.db)
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+		q.Set("db", iw.db)
+		if u.User != nil {
+			q.Set("u", u.User.Username())
+			p, _ := u.User.Password()
+			q.Set("p", p)
+			u.User = nil
+		}
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if iw.token != "" {
+		req.Header.Set("Authorization", "Token "+iw.token)
+	}
+	resp, err := iw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("influx write returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
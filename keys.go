@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// keyTransport sets the X-Api-Key header on each Domain API request, rotating
+// through several keys. In round-robin mode every request uses the next key;
+// otherwise a key is used until Domain says it's out of quota. Either way a
+// request that comes back 429 Too Many Requests is retried with the next key.
+type keyTransport struct {
+	base       http.RoundTripper
+	keys       keySource
+	roundRobin bool
+	next       uint32
+	requests   *prometheus.CounterVec
+}
+
+func newKeyTransport(base http.RoundTripper, keys keySource, roundRobin bool) *keyTransport {
+	return &keyTransport{
+		base:       base,
+		keys:       keys,
+		roundRobin: roundRobin,
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "domain_api_key_requests_total",
+				Help: "Requests made to the Domain API, by API key ID and HTTP status code.",
+			},
+			[]string{"key", "code"},
+		),
+	}
+}
+
+// keySource provides the API keys to use for a request.
+type keySource interface {
+	Keys() ([]string, error)
+}
+
+type staticKeys []string
+
+func (k staticKeys) Keys() ([]string, error) {
+	return k, nil
+}
+
+// keyFile reads API keys from a file, re-reading it whenever it changes so
+// that keys can be rotated without restarting the exporter.
+type keyFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	keys    []string
+}
+
+func (f *keyFile) Keys() ([]string, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.keys, nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	keys := parseAPIKeys(string(b))
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys in %s", f.path)
+	}
+	secrets.add(keys...)
+	if f.keys != nil {
+		slog.Info("Reloaded API keys", "path", f.path, "keys", len(keys))
+	}
+	f.keys, f.modTime, f.size = keys, fi.ModTime(), fi.Size()
+	return f.keys, nil
+}
+
+// parseAPIKeys splits a comma or newline separated list of API keys.
+func parseAPIKeys(s string) []string {
+	var keys []string
+	for _, k := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// keyID identifies an API key in metrics and logs without revealing it.
+func keyID(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:8]
+}
+
+func (t *keyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	keys, err := t.keys.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get API keys: %v", err)
+	}
+	start := atomic.LoadUint32(&t.next)
+	if t.roundRobin {
+		start = atomic.AddUint32(&t.next, 1) - 1
+	}
+	for i := 0; ; i++ {
+		n := (int(start) + i) % len(keys)
+		r := req.Clone(req.Context())
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		r.Header.Set("X-Api-Key", keys[n])
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		t.requests.WithLabelValues(keyID(keys[n]), strconv.Itoa(resp.StatusCode)).Inc()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		if !t.roundRobin {
+			atomic.CompareAndSwapUint32(&t.next, uint32(n), uint32(n+1)%uint32(len(keys)))
+		}
+		// A request whose body can't be read again can't be retried.
+		if i == len(keys)-1 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return resp, nil
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		slog.Warn("API key is out of quota, trying the next one", "key", keyID(keys[n]))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeAPI answers requests 429 Too Many Requests if their key is out of
+// quota, and 200 otherwise, remembering the keys and bodies it was sent.
+type fakeAPI struct {
+	outOfQuota map[string]bool
+	keys       []string
+	bodies     []string
+}
+
+func (f *fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Header.Get("X-Api-Key")
+	f.keys = append(f.keys, key)
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	f.bodies = append(f.bodies, string(body))
+	code := http.StatusOK
+	if f.outOfQuota[key] {
+		code = http.StatusTooManyRequests
+	}
+	return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestKeyTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		keys       []string
+		roundRobin bool
+		outOfQuota []string
+		requests   int
+		// wantKeys are the keys of every request sent to the API, retries
+		// included, and wantCodes the code each request got back.
+		wantKeys  []string
+		wantCodes []int
+	}{
+		{
+			name:      "one key",
+			keys:      []string{"a"},
+			requests:  2,
+			wantKeys:  []string{"a", "a"},
+			wantCodes: []int{200, 200},
+		},
+		{
+			name:      "sticks to a key",
+			keys:      []string{"a", "b"},
+			requests:  3,
+			wantKeys:  []string{"a", "a", "a"},
+			wantCodes: []int{200, 200, 200},
+		},
+		{
+			name:       "moves on when out of quota",
+			keys:       []string{"a", "b", "c"},
+			outOfQuota: []string{"a"},
+			requests:   2,
+			wantKeys:   []string{"a", "b", "b"},
+			wantCodes:  []int{200, 200},
+		},
+		{
+			name:       "all out of quota",
+			keys:       []string{"a", "b"},
+			outOfQuota: []string{"a", "b"},
+			requests:   1,
+			wantKeys:   []string{"a", "b"},
+			wantCodes:  []int{429},
+		},
+		{
+			name:       "round robin",
+			keys:       []string{"a", "b", "c"},
+			roundRobin: true,
+			requests:   4,
+			wantKeys:   []string{"a", "b", "c", "a"},
+			wantCodes:  []int{200, 200, 200, 200},
+		},
+		{
+			name:       "round robin skips keys out of quota",
+			keys:       []string{"a", "b", "c"},
+			roundRobin: true,
+			outOfQuota: []string{"b"},
+			requests:   3,
+			wantKeys:   []string{"a", "b", "c", "c"},
+			wantCodes:  []int{200, 200, 200},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeAPI{outOfQuota: map[string]bool{}}
+			for _, k := range tc.outOfQuota {
+				api.outOfQuota[k] = true
+			}
+			kt := newKeyTransport(api, staticKeys(tc.keys), tc.roundRobin)
+			var codes []int
+			for i := 0; i < tc.requests; i++ {
+				req, _ := http.NewRequest("GET", "https://api.domain.com.au/v1/listings/1", nil)
+				resp, err := kt.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				codes = append(codes, resp.StatusCode)
+			}
+			if !reflect.DeepEqual(api.keys, tc.wantKeys) {
+				t.Errorf("sent keys %q, want %q", api.keys, tc.wantKeys)
+			}
+			if !reflect.DeepEqual(codes, tc.wantCodes) {
+				t.Errorf("got codes %v, want %v", codes, tc.wantCodes)
+			}
+			first := keyID(tc.keys[0])
+			got := testutil.ToFloat64(kt.requests.WithLabelValues(first, "200")) + testutil.ToFloat64(kt.requests.WithLabelValues(first, "429"))
+			if want := float64(countOf(api.keys, tc.keys[0])); got != want {
+				t.Errorf("domain_api_key_requests_total for the first key = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func countOf(ss []string, s string) int {
+	n := 0
+	for _, e := range ss {
+		if e == s {
+			n++
+		}
+	}
+	return n
+}
+
+func TestKeyTransportRetriesBody(t *testing.T) {
+	const body = `{"listingType":"Rent"}`
+	for _, tc := range []struct {
+		name       string
+		getBody    bool
+		wantBodies []string
+		wantCode   int
+	}{
+		{"body can be read again", true, []string{body, body}, 200},
+		{"body can't be read again", false, []string{body}, 429},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeAPI{outOfQuota: map[string]bool{"a": true}}
+			kt := newKeyTransport(api, staticKeys{"a", "b"}, false)
+			req, _ := http.NewRequest("POST", "https://api.domain.com.au/v1/listings/residential/_search", bytes.NewReader([]byte(body)))
+			if !tc.getBody {
+				req.GetBody = nil
+			}
+			resp, err := kt.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("got %d, want %d", resp.StatusCode, tc.wantCode)
+			}
+			if !reflect.DeepEqual(api.bodies, tc.wantBodies) {
+				t.Errorf("sent bodies %q, want %q", api.bodies, tc.wantBodies)
+			}
+		})
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":            nil,
+		"a":           {"a"},
+		"a,b":         {"a", "b"},
+		" a , b ,":    {"a", "b"},
+		"a\nb\n\n c ": {"a", "b", "c"},
+	} {
+		if got := parseAPIKeys(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseAPIKeys(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	f := &keyFile{path: path}
+	if _, err := f.Keys(); err == nil {
+		t.Errorf("Keys() of a missing file succeeded")
+	}
+	for i, tc := range []struct {
+		contents string
+		want     []string
+		wantErr  bool
+	}{
+		{contents: "a\nb\n", want: []string{"a", "b"}},
+		{contents: "a\nb\nc\n", want: []string{"a", "b", "c"}},
+		{contents: "\n", wantErr: true},
+		{contents: "d", want: []string{"d"}},
+	} {
+		if err := os.WriteFile(path, []byte(tc.contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Make sure the change is noticed, even on filesystems with coarse
+		// modification times.
+		mtime := time.Now().Add(time.Duration(i) * time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Keys()
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("Keys() of %q = %v, want error %v", tc.contents, err, tc.wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Keys() of %q = %q, want %q", tc.contents, got, tc.want)
+		}
+	}
+}
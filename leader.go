@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errLeaseConflict is returned by a leaseStore when another replica changed
+// the lease since it was read.
+var errLeaseConflict = errors.New("lease changed by another replica")
+
+// leaseRecord is who holds a lease, and until when.
+type leaseRecord struct {
+	Holder   string        `json:"holder"`
+	Renewed  time.Time     `json:"renewed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// expired reports whether the holder has stopped renewing the lease.
+func (r leaseRecord) expired(now time.Time) bool {
+	return r.Holder == "" || now.After(r.Renewed.Add(r.Duration))
+}
+
+// leaseStore keeps a lease somewhere replicas can all see it. put fails with
+// errLeaseConflict if the lease has changed since the get that returned
+// version.
+type leaseStore interface {
+	get(ctx context.Context) (r leaseRecord, version string, err error)
+	put(ctx context.Context, r leaseRecord, version string) error
+}
+
+// leader elects one of several replicas to push and send notifications, so
+// running two for availability doesn't send everything twice. Every replica
+// still searches when scraped, and keeps track of listings, so a new leader
+// carries on where the old one left off rather than notifying everything
+// again. A nil leader is always leading.
+type leader struct {
+	store    leaseStore
+	identity string
+	duration time.Duration
+
+	mu       sync.Mutex
+	isLeader bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	leading     prometheus.Gauge
+	transitions prometheus.Counter
+}
+
+func newLeader(store leaseStore, identity string, duration time.Duration) *leader {
+	return &leader{
+		store:    store,
+		identity: identity,
+		duration: duration,
+		leading: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "domain_leader",
+			Help: "1 if this replica holds the --ha lease, so pushes and sends notifications, 0 if not.",
+		}),
+		transitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "domain_leader_transitions_total",
+			Help: "Number of times this replica became or stopped being the leader.",
+		}),
+	}
+}
+
+// isLeading reports whether this replica should push and send
+// notifications.
+func (l *leader) isLeading() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.isLeader
+}
+
+// try takes or renews the lease if it can, and returns whether this replica
+// is the leader.
+func (l *leader) try(ctx context.Context) bool {
+	ok, err := l.acquire(ctx)
+	if err != nil && !errors.Is(err, errLeaseConflict) {
+		slog.Error("error renewing the leader lease", "err", err)
+	}
+	l.set(ok)
+	return ok
+}
+
+func (l *leader) acquire(ctx context.Context) (bool, error) {
+	r, version, err := l.store.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if r.Holder != l.identity && !r.expired(now) {
+		return false, nil
+	}
+	err = l.store.put(ctx, leaseRecord{Holder: l.identity, Renewed: now, Duration: l.duration}, version)
+	return err == nil, err
+}
+
+func (l *leader) set(ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ok == l.isLeader {
+		return
+	}
+	l.isLeader = ok
+	l.transitions.Inc()
+	if ok {
+		l.leading.Set(1)
+		slog.Info("Became the leader", "identity", l.identity)
+	} else {
+		l.leading.Set(0)
+		slog.Info("Stopped being the leader", "identity", l.identity)
+	}
+}
+
+// start renews the lease, or tries to take it, three times a lease until
+// stop.
+func (l *leader) start() {
+	if l == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		t := time.NewTicker(l.duration / 3)
+		defer t.Stop()
+		for {
+			l.try(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// stop stops renewing the lease and gives it up, so another replica can take
+// over straight away rather than waiting for it to expire.
+func (l *leader) stop() {
+	if l == nil || l.cancel == nil {
+		return
+	}
+	l.cancel()
+	<-l.done
+	l.release()
+}
+
+// release gives up the lease if this replica holds it.
+func (l *leader) release() {
+	if l == nil || !l.isLeading() {
+		return
+	}
+	l.set(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, version, err := l.store.get(ctx)
+	if err != nil || r.Holder != l.identity {
+		return
+	}
+	if err := l.store.put(ctx, leaseRecord{Duration: l.duration}, version); err != nil {
+		slog.Error("error giving up the leader lease", "err", err)
+	}
+}
+
+// fileLease keeps a lease in a file on a volume the replicas share. Changes
+// are made holding a lock file, created exclusively, so two replicas can't
+// both take an expired lease.
+type fileLease struct {
+	path string
+	// stale is how old a lock file can be before it's assumed to have been
+	// left by a replica that died holding it.
+	stale time.Duration
+}
+
+func (f fileLease) get(ctx context.Context) (leaseRecord, string, error) {
+	var r leaseRecord
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, "", nil
+	}
+	if err != nil {
+		return r, "", err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, "", fmt.Errorf("couldn't parse %s: %v", f.path, err)
+	}
+	return r, string(b), nil
+}
+
+func (f fileLease) put(ctx context.Context, r leaseRecord, version string) error {
+	lock := f.path + ".lock"
+	lf, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > f.stale {
+			os.Remove(lock)
+		}
+		return errLeaseConflict
+	}
+	if err != nil {
+		return err
+	}
+	lf.Close()
+	defer os.Remove(lock)
+	b, _ := os.ReadFile(f.path)
+	if string(b) != version {
+		return errLeaseConflict
+	}
+	nb, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(nb); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// k8sLeaseDir is where Kubernetes mounts a pod's service account.
+const k8sLeaseDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// k8sLease keeps a lease in a Kubernetes coordination.k8s.io/v1 Lease, using
+// the pod's service account, which needs get, create and update on leases.
+// Updates carry the resourceVersion read, so the API server rejects those
+// racing another replica's.
+type k8sLease struct {
+	url string // Of the Lease.
+	// tokenFile is read for every request, as the kubelet rotates projected
+	// service account tokens, which expire after an hour or so.
+	tokenFile string
+	client    *http.Client
+}
+
+// newK8sLease returns a k8sLease for the Lease namespace/name, from inside
+// the cluster.
+func newK8sLease(namespaceName string) (*k8sLease, error) {
+	ns, name, ok := strings.Cut(namespaceName, "/")
+	if !ok {
+		b, err := os.ReadFile(filepath.Join(k8sLeaseDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("pass --ha.k8s-lease as <namespace>/<name>, or run in a pod: %v", err)
+		}
+		ns, name = strings.TrimSpace(string(b)), namespaceName
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("--ha.k8s-lease needs to run in a Kubernetes pod, $KUBERNETES_SERVICE_HOST isn't set")
+	}
+	tokenFile := filepath.Join(k8sLeaseDir, "token")
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(k8sLeaseDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", filepath.Join(k8sLeaseDir, "ca.crt"))
+	}
+	return &k8sLease{
+		url:       fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", net.JoinHostPort(host, port), ns, name),
+		tokenFile: tokenFile,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// k8sMicroTime is the format of Kubernetes' MicroTime.
+const k8sMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type k8sLeaseObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+	} `json:"spec"`
+}
+
+func (k *k8sLease) do(ctx context.Context, method, url string, body interface{}) (*http.Response, error) {
+	token, err := os.ReadFile(k.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	return k.client.Do(req)
+}
+
+func (k *k8sLease) get(ctx context.Context) (leaseRecord, string, error) {
+	var r leaseRecord
+	resp, err := k.do(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return r, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return r, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return r, "", fmt.Errorf("getting lease: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var o k8sLeaseObject
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return r, "", err
+	}
+	r.Holder = o.Spec.HolderIdentity
+	r.Duration = time.Duration(o.Spec.LeaseDurationSeconds) * time.Second
+	if o.Spec.RenewTime != "" {
+		if r.Renewed, err = time.Parse(time.RFC3339Nano, o.Spec.RenewTime); err != nil {
+			return r, "", fmt.Errorf("bad lease renewTime: %v", err)
+		}
+	}
+	return r, o.Metadata.ResourceVersion, nil
+}
+
+func (k *k8sLease) put(ctx context.Context, r leaseRecord, version string) error {
+	var o k8sLeaseObject
+	o.APIVersion, o.Kind = "coordination.k8s.io/v1", "Lease"
+	o.Metadata.Name = k.url[strings.LastIndex(k.url, "/")+1:]
+	o.Metadata.ResourceVersion = version
+	o.Spec.HolderIdentity = r.Holder
+	o.Spec.LeaseDurationSeconds = int((r.Duration + time.Second - 1) / time.Second)
+	if !r.Renewed.IsZero() {
+		o.Spec.RenewTime = r.Renewed.UTC().Format(k8sMicroTime)
+	}
+	method, url := http.MethodPut, k.url
+	if version == "" {
+		// It doesn't exist yet.
+		method, url = http.MethodPost, k.url[:strings.LastIndex(k.url, "/")]
+	}
+	resp, err := k.do(ctx, method, url, o)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return errLeaseConflict
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("updating lease: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLeader(t *testing.T) {
+	ctx := context.Background()
+	store := fileLease{path: filepath.Join(t.TempDir(), "lease"), stale: time.Minute}
+	a := newLeader(store, "a", time.Minute)
+	b := newLeader(store, "b", time.Minute)
+
+	if !a.try(ctx) {
+		t.Fatal("a didn't take a lease no one held")
+	}
+	if b.try(ctx) {
+		t.Fatal("b took the lease a holds")
+	}
+	if !a.try(ctx) {
+		t.Fatal("a couldn't renew its lease")
+	}
+	if !a.isLeading() || b.isLeading() {
+		t.Errorf("isLeading() = %v, %v, want a leading", a.isLeading(), b.isLeading())
+	}
+
+	a.release()
+	if a.isLeading() {
+		t.Error("a is still leading after releasing the lease")
+	}
+	if !b.try(ctx) {
+		t.Fatal("b couldn't take the lease a released")
+	}
+	if got := testutil.ToFloat64(a.transitions); got != 2 {
+		t.Errorf("a made %v transitions, want 2", got)
+	}
+	if got := testutil.ToFloat64(b.leading); got != 1 {
+		t.Errorf("b's domain_leader = %v, want 1", got)
+	}
+
+	// b stops renewing without releasing, like a replica that died.
+	r, version, err := store.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Renewed = time.Now().Add(-2 * time.Minute)
+	if err := store.put(ctx, r, version); err != nil {
+		t.Fatal(err)
+	}
+	if !a.try(ctx) {
+		t.Error("a couldn't take the lease b let expire")
+	}
+
+	var nobody *leader
+	if !nobody.isLeading() {
+		t.Error("a nil leader isn't leading")
+	}
+}
+
+func TestFileLeasePut(t *testing.T) {
+	ctx := context.Background()
+	store := fileLease{path: filepath.Join(t.TempDir(), "lease"), stale: time.Minute}
+	if err := store.put(ctx, leaseRecord{Holder: "a"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put(ctx, leaseRecord{Holder: "b"}, ""); !errors.Is(err, errLeaseConflict) {
+		t.Errorf("put() with an old version = %v, want a conflict", err)
+	}
+
+	_, version, err := store.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := store.path + ".lock"
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put(ctx, leaseRecord{Holder: "b"}, version); !errors.Is(err, errLeaseConflict) {
+		t.Errorf("put() while locked = %v, want a conflict", err)
+	}
+	// A lock left behind by a replica that died is cleared, for the next
+	// try to succeed.
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	store.put(ctx, leaseRecord{Holder: "b"}, version)
+	if err := store.put(ctx, leaseRecord{Holder: "b"}, version); err != nil {
+		t.Errorf("put() after a stale lock = %v", err)
+	}
+	if r, _, _ := store.get(ctx); r.Holder != "b" {
+		t.Errorf("holder = %q, want b", r.Holder)
+	}
+}
+
+// fakeLeases is a Kubernetes API server with just enough of Leases for
+// k8sLease.
+type fakeLeases struct {
+	mu      sync.Mutex
+	lease   *k8sLeaseObject
+	version int
+	tokens  []string
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, r.Header.Get("Authorization"))
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost, http.MethodPut:
+		var o k8sLeaseObject
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (r.Method == http.MethodPost) != (f.lease == nil) || (f.lease != nil && o.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion) {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		f.version++
+		o.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.lease = &o
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(o)
+	}
+}
+
+func TestK8sLease(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeLeases{}
+	srv := httptest.NewTLSServer(api)
+	defer srv.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := &k8sLease{
+		url:       srv.URL + "/apis/coordination.k8s.io/v1/namespaces/default/leases/domain-exporter",
+		tokenFile: tokenFile,
+		client:    srv.Client(),
+	}
+	l := newLeader(store, "a", 15*time.Second)
+	if !l.try(ctx) {
+		t.Fatal("couldn't create the lease")
+	}
+	r, version, err := store.get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Holder != "a" || r.Duration != 15*time.Second || time.Since(r.Renewed) > time.Minute {
+		t.Errorf("get() = %+v", r)
+	}
+	if err := store.put(ctx, r, "0"); !errors.Is(err, errLeaseConflict) {
+		t.Errorf("put() with an old resourceVersion = %v, want a conflict", err)
+	}
+
+	// The kubelet rotates the token.
+	if err := os.WriteFile(tokenFile, []byte("token-2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !l.try(ctx) {
+		t.Fatal("couldn't renew the lease")
+	}
+	if _, v, _ := store.get(ctx); v == version {
+		t.Error("renewing didn't update the lease")
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if first, last := api.tokens[0], api.tokens[len(api.tokens)-1]; first != "Bearer token-1" || last != "Bearer token-2" {
+		t.Errorf("sent %q then %q, want the token file's contents each time", first, last)
+	}
+}
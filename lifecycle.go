@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// lifecycle serves /-/reload, /-/quiesce and /-/quit, like Prometheus's
+// --web.enable-lifecycle endpoints, so orchestration can manage the exporter
+// the same way.
+type lifecycle struct {
+	dc   domainCollector
+	auth *httpAuth
+	// quit is sent why the exporter should shut down.
+	quit chan<- string
+}
+
+// reload reads the searches and alert rules again, keeping the old ones if
+// they're bad. It's also run on SIGHUP.
+func (dc domainCollector) reload() error {
+	var errs []string
+	if err := dc.searches.reload(); err != nil {
+		errs = append(errs, fmt.Sprintf("couldn't reload searches: %v", err))
+	}
+	if dc.alerts != nil {
+		if err := dc.alerts.reload(); err != nil {
+			errs = append(errs, fmt.Sprintf("couldn't reload alerts: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	slog.Info("Reloaded config", "searches", len(dc.searches.names()))
+	return nil
+}
+
+// register adds the lifecycle endpoints to mux. They change things, so only
+// take POST or PUT, as Prometheus's do.
+func (l lifecycle) register(mux *http.ServeMux) {
+	mux.HandleFunc("/-/reload", l.handle(func(w http.ResponseWriter) {
+		if err := l.dc.reload(); err != nil {
+			slog.Error("error reloading config", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "reloaded")
+	}))
+	mux.HandleFunc("/-/quiesce", l.handle(func(w http.ResponseWriter) {
+		l.dc.health.quiesce()
+		slog.Info("Quiescing, no more searches will be run")
+		fmt.Fprintln(w, "quiescing")
+	}))
+	mux.HandleFunc("/-/quit", l.handle(func(w http.ResponseWriter) {
+		fmt.Fprintln(w, "shutting down")
+		select {
+		case l.quit <- "/-/quit":
+		default:
+			// Already shutting down.
+		}
+	}))
+}
+
+func (l lifecycle) handle(h func(w http.ResponseWriter)) http.HandlerFunc {
+	return l.auth.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "use POST or PUT", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w)
+	})
+}
+
+// unlessQuiesced returns h, responding 503 once the exporter is quiescing.
+func (dc domainCollector) unlessQuiesced(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dc.health.quiescing() {
+			http.Error(w, "the exporter is quiescing, and not running searches", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// listenAndServe serves srv on addr, which is either a TCP address or a
+// unix:///path/to/socket URL, or on the sockets passed by systemd if
+// flags.WebSystemdSocket is set.
+func listenAndServe(srv *http.Server, addr string, flags *web.FlagConfig) error {
+	if *flags.WebSystemdSocket || !strings.HasPrefix(addr, "unix://") {
+		flags.WebListenAddresses = &[]string{addr}
+		return web.ListenAndServe(srv, flags, slog.Default())
+	}
+	path := strings.TrimPrefix(addr, "unix://")
+	// Clean up a socket left behind by a previous run that didn't exit
+	// cleanly, but don't delete anything else.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return web.ServeMultiple([]net.Listener{l}, srv, flags, slog.Default())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mhansen/domain"
+)
+
+// locationAllowlist limits where ad-hoc searches can search, so an exporter
+// shared across a team only spends quota on the places the team cares about.
+type locationAllowlist []allowedLocation
+
+// allowedLocation is one entry in a locationAllowlist. Searches match it if
+// they're for everything it gives.
+type allowedLocation struct {
+	state, suburb, postCode string
+}
+
+// parseLocationAllowlist parses a comma separated list of states, like NSW,
+// suburbs, like NSW/Pyrmont, and postcodes, like 2009. It returns nil if
+// there are none.
+func parseLocationAllowlist(s string) (locationAllowlist, error) {
+	var l locationAllowlist
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if postcodeRE.MatchString(e) {
+			l = append(l, allowedLocation{postCode: e})
+			continue
+		}
+		state, suburb, _ := strings.Cut(e, "/")
+		state = strings.ToUpper(strings.TrimSpace(state))
+		if !slices.Contains(states, state) {
+			return nil, fmt.Errorf("bad location %q, want a state, state/suburb or postcode", e)
+		}
+		l = append(l, allowedLocation{state: state, suburb: strings.Join(strings.Fields(suburb), " ")})
+	}
+	return l, nil
+}
+
+// allows reports whether searches in loc are allowed. Every search is
+// allowed by an empty allowlist. Surrounding suburbs are only allowed by
+// whole states, as they can be outside any narrower entry.
+func (l locationAllowlist) allows(loc domain.LocationFilter) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, a := range l {
+		wholeState := a.suburb == "" && a.postCode == ""
+		if (a.state == "" || a.state == loc.State) &&
+			(a.suburb == "" || strings.EqualFold(a.suburb, loc.Suburb)) &&
+			(a.postCode == "" || a.postCode == loc.PostCode) &&
+			(!loc.IncludeSurroundingSuburbs || wholeState) {
+			return true
+		}
+	}
+	return false
+}
+
+// locationName returns loc like an address ends, like "Pyrmont NSW 2009".
+func locationName(loc domain.LocationFilter) string {
+	var parts []string
+	for _, p := range []string{loc.Suburb, loc.State, loc.PostCode} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
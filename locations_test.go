@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mhansen/domain"
+)
+
+func TestParseLocationAllowlist(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    locationAllowlist
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: " , ", want: nil},
+		{in: "NSW", want: locationAllowlist{{state: "NSW"}}},
+		{in: "nsw", want: locationAllowlist{{state: "NSW"}}},
+		{in: "NSW/Pyrmont", want: locationAllowlist{{state: "NSW", suburb: "Pyrmont"}}},
+		{in: "NSW/  Surry   Hills ", want: locationAllowlist{{state: "NSW", suburb: "Surry Hills"}}},
+		{in: "2009", want: locationAllowlist{{postCode: "2009"}}},
+		{
+			in:   "VIC, NSW/Pyrmont,2009",
+			want: locationAllowlist{{state: "VIC"}, {state: "NSW", suburb: "Pyrmont"}, {postCode: "2009"}},
+		},
+		{in: "Pyrmont", wantErr: true},
+		{in: "XYZ/Pyrmont", wantErr: true},
+		{in: "NSW,200", wantErr: true},
+	} {
+		got, err := parseLocationAllowlist(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseLocationAllowlist(%q) = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLocationAllowlist(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLocationAllowlistAllows(t *testing.T) {
+	l, err := parseLocationAllowlist("VIC,NSW/Pyrmont,2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		l    locationAllowlist
+		loc  domain.LocationFilter
+		want bool
+	}{
+		{"empty allows anything", nil, domain.LocationFilter{State: "QLD"}, true},
+		{"empty allows surrounding suburbs", nil, domain.LocationFilter{State: "QLD", Suburb: "Noosa", IncludeSurroundingSuburbs: true}, true},
+		{"whole state", l, domain.LocationFilter{State: "VIC"}, true},
+		{"suburb in an allowed state", l, domain.LocationFilter{State: "VIC", Suburb: "Richmond"}, true},
+		{"allowed suburb", l, domain.LocationFilter{State: "NSW", Suburb: "Pyrmont"}, true},
+		{"allowed suburb in another case", l, domain.LocationFilter{State: "NSW", Suburb: "pyrmont"}, true},
+		{"allowed suburb with a postcode", l, domain.LocationFilter{State: "NSW", Suburb: "Pyrmont", PostCode: "2009"}, true},
+		{"other suburb", l, domain.LocationFilter{State: "NSW", Suburb: "Ultimo"}, false},
+		{"whole state with an allowed suburb", l, domain.LocationFilter{State: "NSW"}, false},
+		{"same suburb in another state", l, domain.LocationFilter{State: "QLD", Suburb: "Pyrmont"}, false},
+		{"allowed postcode", l, domain.LocationFilter{PostCode: "2000"}, true},
+		{"allowed postcode with a suburb", l, domain.LocationFilter{State: "NSW", Suburb: "Sydney", PostCode: "2000"}, true},
+		{"other postcode", l, domain.LocationFilter{PostCode: "2010"}, false},
+		{"surrounding suburbs in an allowed state", l, domain.LocationFilter{State: "VIC", Suburb: "Richmond", IncludeSurroundingSuburbs: true}, true},
+		{"surrounding suburbs of an allowed suburb", l, domain.LocationFilter{State: "NSW", Suburb: "Pyrmont", IncludeSurroundingSuburbs: true}, false},
+		{"surrounding suburbs of an allowed postcode", l, domain.LocationFilter{PostCode: "2000", IncludeSurroundingSuburbs: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.l.allows(tc.loc); got != tc.want {
+				t.Errorf("allows(%+v) = %v, want %v", tc.loc, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// setupLogging makes slog, and the log package used by the domain client,
+// write to stderr in the given format and level, with secrets redacted.
+func setupLogging(format, level string) {
+	w := redactWriter{os.Stderr}
+	opts := &slog.HandlerOptions{}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		slog.Error("unknown --log.level, want debug, info, warn or error", "level", level)
+		os.Exit(1)
+	}
+	opts.Level = l
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		slog.Error("unknown --log.format, want text or json", "format", format)
+		os.Exit(1)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// debugTransport logs each Domain API request and the size of its response
+// at debug level, to help diagnose misconfigured queries.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !slog.Default().Enabled(req.Context(), slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+	var body []byte
+	if req.GetBody != nil {
+		if b, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(b)
+			b.Close()
+		}
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		slog.Debug("upstream request failed", "request_id", requestID(req.Context()), "method", req.Method, "url", req.URL.String(), "request", string(body), "duration", time.Since(start), "err", err)
+		return nil, err
+	}
+	resp.Body = &countingReader{ReadCloser: resp.Body, done: func(n int64) {
+		slog.Debug("upstream request", "request_id", requestID(req.Context()), "method", req.Method, "url", req.URL.String(), "request", string(body), "status", resp.StatusCode, "response_bytes", n, "duration", time.Since(start))
+	}}
+	return resp, nil
+}
+
+// countingReader calls done with the number of bytes read when it's closed.
+type countingReader struct {
+	io.ReadCloser
+	n    int64
+	done func(n int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	r.done(r.n)
+	return r.ReadCloser.Close()
+}
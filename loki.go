@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// lokiStream is one stream of a Loki push request, see
+// https://grafana.com/docs/loki/latest/reference/loki-http-api/#ingest-logs.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// newLokiNotifier pushes each event to Loki as a JSON log line, in a stream
+// labelled with its search and type. Loki's URL can include a username and
+// password for basic auth, and tenant sets X-Scope-OrgID for multi-tenant
+// Lokis.
+func newLokiNotifier(url, tenant string) *webhookNotifier {
+	n := newWebhookNotifier(strings.TrimSuffix(url, "/") + "/loki/api/v1/push")
+	if tenant != "" {
+		n.header = http.Header{}
+		n.header.Set("X-Scope-OrgID", tenant)
+	}
+	n.encode = func(events []listingEvent) ([]byte, error) {
+		var streams []*lokiStream
+		byLabels := map[[2]string]*lokiStream{}
+		for _, e := range events {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			k := [2]string{e.Search, e.Type}
+			s, ok := byLabels[k]
+			if !ok {
+				s = &lokiStream{Stream: map[string]string{"job": "domain_exporter", "search": e.Search, "type": e.Type}}
+				byLabels[k] = s
+				streams = append(streams, s)
+			}
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+		}
+		return json.Marshal(map[string]interface{}{"streams": streams})
+	}
+	return n
+}
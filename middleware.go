@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades through. The upgrade writes its response to
+// the connection directly, so it's recorded here.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// accessLog logs every request to w, in Common Log Format or as JSON.
+func accessLog(h http.Handler, format string, w io.Writer) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if format == "json" {
+			json.NewEncoder(w).Encode(struct {
+				Time       time.Time   `json:"time"`
+				RequestID  string      `json:"request_id"`
+				RemoteAddr string      `json:"remote_addr"`
+				Method     string      `json:"method"`
+				Path       string      `json:"path"`
+				Params     interface{} `json:"params"`
+				Status     int         `json:"status"`
+				Bytes      int         `json:"bytes"`
+				Duration   float64     `json:"duration_seconds"`
+			}{start, requestID(r.Context()), r.RemoteAddr, r.Method, r.URL.Path, r.URL.Query(), rec.status, rec.bytes, time.Since(start).Seconds()})
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		fmt.Fprintf(w, "%s - - [%s] %q %d %d %.3f\n",
+			host, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+			rec.status, rec.bytes, time.Since(start).Seconds())
+	})
+}
+
+// requireClientNames only lets through requests with a verified client
+// certificate whose common name or one of whose subject alternative names is
+// in names. The web config file's client_allowed_sans doesn't check common
+// names, which older PKIs still put client identities in.
+func requireClientNames(h http.Handler, names []string) http.Handler {
+	allowed := newAllowedNames(names)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := allowed.check(r.TLS, r.RemoteAddr); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowedNames is a set of names a client certificate must have one of.
+type allowedNames map[string]bool
+
+func newAllowedNames(names []string) allowedNames {
+	allowed := allowedNames{}
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			allowed[n] = true
+		}
+	}
+	return allowed
+}
+
+// check returns an error unless state has a verified client certificate
+// with one of the allowed names.
+func (allowed allowedNames) check(state *tls.ConnectionState, remoteAddr string) error {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return errors.New("a client certificate is required")
+	}
+	cert := state.VerifiedChains[0][0]
+	certNames := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	certNames = append(certNames, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		certNames = append(certNames, ip.String())
+	}
+	for _, u := range cert.URIs {
+		certNames = append(certNames, u.String())
+	}
+	for _, n := range certNames {
+		if allowed[n] {
+			return nil
+		}
+	}
+	slog.Debug("rejected client certificate", "subject", cert.Subject.String(), "remote_addr", remoteAddr)
+	return errors.New("client certificate not allowed")
+}
+
+// timeoutExempt are the paths withTimeout leaves alone: event streams, which
+// stay open, backups, which take as long as the database is big, and
+// profiles, which take as long as they're asked to.
+var timeoutExempt = []string{"/sse/events", "/ws/events", "/admin/backup", "/admin/restore", "/debug/pprof/"}
+
+// withTimeout gives requests timeout to be answered, after which they get a
+// 503 response, are counted in timeouts, and have their context cancelled,
+// stopping any Domain API calls made for them, so slow calls can't pile up.
+func withTimeout(h http.Handler, timeout time.Duration, timeouts prometheus.Counter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range timeoutExempt {
+			if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		var finished atomic.Bool
+		th := http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			finished.Store(true)
+		}), timeout, fmt.Sprintf("no response within --web.request-timeout of %v\n", timeout))
+		th.ServeHTTP(w, r)
+		if !finished.Load() {
+			timeouts.Inc()
+			slog.Warn("request timed out", "request_id", requestID(r.Context()), "path", r.URL.Path, "timeout", timeout)
+		}
+	})
+}
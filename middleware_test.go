@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWithTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     string
+		takes    time.Duration
+		wantCode int
+		// wantCancelled is whether the handler's context is cancelled before
+		// it finishes.
+		wantCancelled bool
+	}{
+		{name: "fast", path: "/listings", wantCode: 200},
+		{name: "slow", path: "/listings", takes: time.Second, wantCode: 503, wantCancelled: true},
+		{name: "slow event stream", path: "/sse/events", takes: 100 * time.Millisecond, wantCode: 200},
+		{name: "slow profile", path: "/debug/pprof/profile", takes: 100 * time.Millisecond, wantCode: 200},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			timeouts := prometheus.NewCounter(prometheus.CounterOpts{Name: "timeouts_total"})
+			cancelled := make(chan bool, 1)
+			h := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tc.takes):
+					cancelled <- false
+				case <-r.Context().Done():
+					cancelled <- true
+					return
+				}
+				w.Write([]byte("ok"))
+			}), 20*time.Millisecond, timeouts)
+			r := httptest.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Errorf("got %d, want %d", w.Code, tc.wantCode)
+			}
+			if got := <-cancelled; got != tc.wantCancelled {
+				t.Errorf("cancelled = %v, want %v", got, tc.wantCancelled)
+			}
+			want := 0.0
+			if tc.wantCode == 503 {
+				want = 1
+			}
+			if got := testutil.ToFloat64(timeouts); got != want {
+				t.Errorf("counted %v timeouts, want %v", got, want)
+			}
+		})
+	}
+}
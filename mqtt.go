@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTimeout is how long to wait for the broker to acknowledge a publish.
+const mqttTimeout = 10 * time.Second
+
+// mqttNotifier publishes each event as JSON to an MQTT broker, on a topic
+// made from a template executed with the event.
+type mqttNotifier struct {
+	client mqtt.Client
+	topic  *template.Template
+}
+
+func newMQTTNotifier(broker, clientID, username, password, topic string) (*mqttNotifier, error) {
+	tmpl, err := template.New("topic").Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("bad topic template: %v", err)
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetConnectRetry(true).
+		SetAutoReconnect(true)
+	c := mqtt.NewClient(opts)
+	// With SetConnectRetry, Connect keeps trying in the background, and
+	// publishes wait until it's connected.
+	c.Connect()
+	return &mqttNotifier{client: c, topic: tmpl}, nil
+}
+
+// Notify implements notifier.
+func (n *mqttNotifier) Notify(ctx context.Context, events []listingEvent) error {
+	for _, e := range events {
+		var topic strings.Builder
+		if err := n.topic.Execute(&topic, e); err != nil {
+			return fmt.Errorf("couldn't render topic: %v", err)
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		t := n.client.Publish(topic.String(), 1, false, payload)
+		if !t.WaitTimeout(mqttTimeout) {
+			return fmt.Errorf("timed out publishing to %s", topic.String())
+		}
+		if err := t.Error(); err != nil {
+			return fmt.Errorf("couldn't publish to %s: %v", topic.String(), err)
+		}
+	}
+	return nil
+}
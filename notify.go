@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// notifier sends listing events somewhere people will see them.
+type notifier interface {
+	Notify(ctx context.Context, events []listingEvent) error
+}
+
+// eventFilter picks out the events worth notifying about.
+type eventFilter struct {
+	types       map[string]bool
+	maxPrice    float64
+	minBedrooms float64
+}
+
+func newEventFilter(types string, maxPrice, minBedrooms float64) (eventFilter, error) {
+	f := eventFilter{types: map[string]bool{}, maxPrice: maxPrice, minBedrooms: minBedrooms}
+	for _, t := range strings.Split(types, ",") {
+		t = strings.TrimSpace(t)
+		switch t {
+		case "":
+		case eventNew, eventPriceDrop, eventPriceRise, eventRemoved:
+			f.types[t] = true
+		default:
+			return f, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return f, nil
+}
+
+func (f eventFilter) match(e listingEvent) bool {
+	if !f.types[e.Type] {
+		return false
+	}
+	if f.maxPrice > 0 && (e.Price == 0 || e.Price > f.maxPrice) {
+		return false
+	}
+	if float64(e.Listing.Bedrooms) < f.minBedrooms {
+		return false
+	}
+	return true
+}
+
+// notifications sends events to notifiers in the background, so that slow
+// notifiers don't hold up scrapes. Notifiers for people only get the events
+// that match the filter, and alerts, while streams for other programs get
+// every event.
+type notifications struct {
+	filter    eventFilter
+	notifiers map[string]notifier
+	streams   map[string]bool
+	queue     chan []listingEvent
+	sent      *prometheus.CounterVec
+	dropped   prometheus.Counter
+	// leader, if set, decides whether this replica sends notifications.
+	leader *leader
+}
+
+// notificationQueueSize is how many batches of events can be waiting to be
+// sent before new ones are dropped.
+const notificationQueueSize = 100
+
+func newNotifications(filter eventFilter, notifiers, streams map[string]notifier) *notifications {
+	n := &notifications{
+		filter:    filter,
+		notifiers: map[string]notifier{},
+		streams:   map[string]bool{},
+		queue:     make(chan []listingEvent, notificationQueueSize),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_notifications_total",
+			Help: "Number of batches of listing events sent to each notifier, by whether they were delivered.",
+		}, []string{"notifier", "result"}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "domain_notifications_dropped_total",
+			Help: "Number of batches of listing events dropped because the notification queue was full.",
+		}),
+	}
+	for name, nt := range notifiers {
+		n.notifiers[name] = nt
+	}
+	for name, nt := range streams {
+		n.notifiers[name] = nt
+		n.streams[name] = true
+	}
+	for name := range n.notifiers {
+		n.sent.WithLabelValues(name, "success")
+		n.sent.WithLabelValues(name, "failure")
+	}
+	return n
+}
+
+// Describe implements prometheus.Collector.
+func (n *notifications) Describe(ch chan<- *prometheus.Desc) {
+	n.sent.Describe(ch)
+	n.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (n *notifications) Collect(ch chan<- prometheus.Metric) {
+	n.sent.Collect(ch)
+	n.dropped.Collect(ch)
+}
+
+// send queues events to be sent.
+func (n *notifications) send(events []listingEvent) {
+	if n == nil || len(n.notifiers) == 0 || len(events) == 0 {
+		return
+	}
+	select {
+	case n.queue <- events:
+	default:
+		n.dropped.Inc()
+		slog.Warn("notification queue full, dropping events", "events", len(events))
+	}
+}
+
+// run sends queued events until ctx is done.
+func (n *notifications) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-n.queue:
+			if !n.leader.isLeading() {
+				continue
+			}
+			var matched []listingEvent
+			for _, e := range events {
+				if e.Type == eventAlert || n.filter.match(e) {
+					matched = append(matched, e)
+				}
+			}
+			for name, nt := range n.notifiers {
+				batch := matched
+				if n.streams[name] {
+					batch = events
+				}
+				if len(batch) == 0 {
+					continue
+				}
+				if err := nt.Notify(ctx, batch); err != nil {
+					n.sent.WithLabelValues(name, "failure").Inc()
+					slog.Error("error sending notification", "notifier", name, "events", len(batch), "err", secrets.redact(err.Error()))
+					continue
+				}
+				n.sent.WithLabelValues(name, "success").Inc()
+			}
+		}
+	}
+}
+
+// webhookAttempts is how many times a webhook is tried before giving up.
+const webhookAttempts = 3
+
+// webhookBackoff is how long to wait before retrying a webhook the first
+// time, doubling each time after.
+var webhookBackoff = time.Second
+
+// webhookNotifier POSTs events to a URL, as JSON by default.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	// header is added to every request.
+	header http.Header
+	// encode makes the request body for a batch of events.
+	encode func([]listingEvent) ([]byte, error)
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		encode: func(events []listingEvent) ([]byte, error) {
+			return json.Marshal(map[string]interface{}{"events": events})
+		},
+	}
+}
+
+// Notify implements notifier. Network errors and 5xx responses are retried
+// with backoff.
+func (wh *webhookNotifier) Notify(ctx context.Context, events []listingEvent) error {
+	body, err := wh.encode(events)
+	if err != nil {
+		return err
+	}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = wh.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is an error that retrying won't fix.
+type permanentError struct{ error }
+
+func (wh *webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	for k, v := range wh.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "domain-exporter/"+version)
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return permanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	return nil
+}
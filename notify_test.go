@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventFilter(t *testing.T) {
+	f, err := newEventFilter("new,price_drop", 600, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := func(typ string, price float64, bedrooms float32) listingEvent {
+		e := listingEvent{Type: typ, Price: price}
+		e.Listing.Bedrooms = bedrooms
+		return e
+	}
+	for _, tc := range []struct {
+		name string
+		e    listingEvent
+		want bool
+	}{
+		{"matches", event(eventNew, 500, 2), true},
+		{"price drop", event(eventPriceDrop, 500, 3), true},
+		{"type not asked for", event(eventRemoved, 500, 2), false},
+		{"too dear", event(eventNew, 700, 2), false},
+		{"no price", event(eventNew, 0, 2), false},
+		{"too small", event(eventNew, 500, 1), false},
+	} {
+		if got := f.match(tc.e); got != tc.want {
+			t.Errorf("%s: match() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if _, err := newEventFilter("new,sold", 0, 0); err == nil {
+		t.Errorf("newEventFilter with an unknown type succeeded")
+	}
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	defer func(d time.Duration) { webhookBackoff = d }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+	for _, tc := range []struct {
+		name         string
+		codes        []int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{"delivered", []int{200}, 1, false},
+		{"retried after a server error", []int{503, 200}, 2, false},
+		{"gives up", []int{500, 502, 503, 200}, webhookAttempts, true},
+		{"client errors aren't retried", []int{400, 200}, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type = %q, want JSON", r.Header.Get("Content-Type"))
+				}
+				w.WriteHeader(tc.codes[n-1])
+			}))
+			defer srv.Close()
+			wh := newWebhookNotifier(srv.URL)
+			err := wh.Notify(context.Background(), []listingEvent{{Type: eventNew}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Notify() = %v, want error %v", err, tc.wantErr)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
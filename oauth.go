@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// newOAuthTransport authenticates requests to the Domain API with an OAuth2
+// access token obtained using the client credentials grant. Tokens are
+// refreshed automatically before they expire.
+func newOAuthTransport(base http.RoundTripper, clientID, clientSecret, tokenURL, scopes string) http.RoundTripper {
+	cfg := clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       strings.Fields(strings.Replace(scopes, ",", " ", -1)),
+	}
+	// Fetch tokens through the same transport as API requests, so they get
+	// the same proxy settings.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: base})
+	return &oauth2.Transport{
+		Source: cfg.TokenSource(ctx),
+		Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// The domain client always sets an API key header, which is
+			// empty when we're using OAuth.
+			if req.Header.Get("X-Api-Key") == "" {
+				req.Header.Del("X-Api-Key")
+			}
+			return base.RoundTrip(req)
+		}),
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	prombridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/protobuf/proto"
+)
+
+// setupOTLPMetrics pushes everything registered with g to an OpenTelemetry
+// collector over OTLP/HTTP every interval. The endpoint is configured by the
+// standard OTEL_EXPORTER_OTLP_* environment variables. The returned function
+// pushes any pending metrics and stops.
+func setupOTLPMetrics(ctx context.Context, g prometheus.Gatherer, interval time.Duration) (func(context.Context) error, error) {
+	exp, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("domain_exporter"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	mp := metric.NewMeterProvider(
+		metric.WithResource(res),
+		metric.WithReader(metric.NewPeriodicReader(exp,
+			metric.WithInterval(interval),
+			metric.WithProducer(prombridge.NewMetricProducer(prombridge.WithGatherer(g))),
+		)),
+	)
+	return mp.Shutdown, nil
+}
+
+// otlpModules is a pusher keeping the latest listing metrics of each module
+// the push loop searches, with a module label, for the OTLP exporter to
+// gather on its own schedule.
+type otlpModules struct {
+	searches *searchSet
+
+	mu     sync.Mutex
+	latest map[string][]*dto.MetricFamily
+}
+
+func newOTLPModules(searches *searchSet) *otlpModules {
+	return &otlpModules{searches: searches, latest: map[string][]*dto.MetricFamily{}}
+}
+
+// Push implements pusher.
+func (o *otlpModules) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("module"), Value: proto.String(module)})
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.latest[module] = mfs
+	return nil
+}
+
+// Gather implements prometheus.Gatherer, merging the modules' metrics.
+// Modules that have since been removed are left out.
+func (o *otlpModules) Gather() ([]*dto.MetricFamily, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	byName := map[string]*dto.MetricFamily{}
+	for module, mfs := range o.latest {
+		if _, ok := o.searches.get(module); !ok {
+			delete(o.latest, module)
+			continue
+		}
+		for _, mf := range mfs {
+			f, ok := byName[mf.GetName()]
+			if !ok {
+				f = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				byName[mf.GetName()] = f
+			}
+			f.Metric = append(f.Metric, mf.Metric...)
+		}
+	}
+	families := make([]*dto.MetricFamily, 0, len(byName))
+	for _, f := range byName {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, nil
+}
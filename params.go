@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a request's parameters are rejected, the reason label of
+// domain_bad_request_total.
+const (
+	reasonUnknownParam     = "unknown_param"
+	reasonRepeatedParam    = "repeated_param"
+	reasonConflictingParam = "conflicting_params"
+	reasonMissingParam     = "missing_param"
+	reasonUnknownModule    = "unknown_module"
+	reasonInvalidState     = "invalid_state"
+	reasonInvalidPostcode  = "invalid_postcode"
+	reasonInvalidValue     = "invalid_value"
+	reasonAdHocSearch      = "ad_hoc_search"
+	reasonLocation         = "location_not_allowed"
+	reasonSearchCost       = "over_search_cost"
+	reasonURLTooLong       = "url_too_long"
+	reasonTooManyParams    = "too_many_params"
+	reasonBodyTooLarge     = "body_too_large"
+	reasonRawPayloads      = "raw_payloads_off"
+)
+
+// paramError is a bad request parameter. It's returned to clients as the body
+// of a 400 response, so they can tell which parameter was wrong and why.
+type paramError struct {
+	Message string `json:"error"`
+	Reason  string `json:"reason"`
+	Param   string `json:"param,omitempty"`
+	Value   string `json:"value,omitempty"`
+	// code is the response's status code, if not 400.
+	code int
+}
+
+func (e *paramError) Error() string { return e.Message }
+
+// badParam returns a paramError for the value of param.
+func badParam(reason, param, value, format string, a ...any) *paramError {
+	return &paramError{Message: fmt.Sprintf(format, a...), Reason: reason, Param: param, Value: value}
+}
+
+func newBadRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "domain_bad_request_total",
+		Help: "Number of requests rejected because of bad query parameters, or for being too big, by reason.",
+	}, []string{"reason"})
+}
+
+// checkParams returns an error if q has parameters other than allowed, or
+// more than one value for one, which would otherwise be ignored.
+func checkParams(q url.Values, allowed ...string) error {
+	names := make([]string, 0, len(q))
+	for k := range q {
+		names = append(names, k)
+	}
+	// Sorted, so the same request always gets the same error.
+	slices.Sort(names)
+	for _, k := range names {
+		if !slices.Contains(allowed, k) {
+			return badParam(reasonUnknownParam, k, "", "unknown parameter %q, want one of %s", k, strings.Join(allowed, ", "))
+		}
+		if len(q[k]) > 1 {
+			return badParam(reasonRepeatedParam, k, "", "parameter %q given %d times, want once", k, len(q[k]))
+		}
+	}
+	return nil
+}
+
+// rejected counts a request rejected because of err, returning err as a
+// paramError.
+func (dc domainCollector) rejected(err error) *paramError {
+	var pe *paramError
+	if !errors.As(err, &pe) {
+		pe = &paramError{Message: err.Error(), Reason: reasonInvalidValue}
+	}
+	dc.badRequests.WithLabelValues(pe.Reason).Inc()
+	return pe
+}
+
+// badRequest responds to a request rejected because of err, with 400 unless
+// err says otherwise, and a JSON body saying which parameter was bad and why.
+func (dc domainCollector) badRequest(w http.ResponseWriter, err error) {
+	pe := dc.rejected(err)
+	code := http.StatusBadRequest
+	if pe.code != 0 {
+		code = pe.code
+	}
+	writeJSON(w, code, pe)
+}
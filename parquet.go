@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2/google"
+)
+
+// parquetRow is a listing snapshot in a Parquet file. The columns match the
+// listing_snapshots table of --history.db.
+type parquetRow struct {
+	ObservedAt   time.Time `parquet:"observed_at,timestamp(millisecond)"`
+	Search       string    `parquet:"search,dict"`
+	ListingID    int64     `parquet:"listing_id"`
+	Address      string    `parquet:"address"`
+	Suburb       string    `parquet:"suburb,dict"`
+	State        string    `parquet:"state,dict"`
+	Postcode     string    `parquet:"postcode,dict"`
+	PropertyType string    `parquet:"property_type,dict"`
+	Bedrooms     float32   `parquet:"bedrooms"`
+	Bathrooms    float32   `parquet:"bathrooms"`
+	Carspaces    int32     `parquet:"carspaces"`
+	DisplayPrice string    `parquet:"display_price"`
+	Price        float64   `parquet:"price"`
+	DateListed   string    `parquet:"date_listed"`
+	URL          string    `parquet:"url"`
+}
+
+// writeParquet writes listings as a Parquet file to w.
+func writeParquet(w io.Writer, search string, observed time.Time, listings []listingSummary) error {
+	pw := parquet.NewGenericWriter[parquetRow](w)
+	rows := make([]parquetRow, len(listings))
+	for i, l := range listings {
+		rows[i] = parquetRow{
+			ObservedAt:   observed.UTC(),
+			Search:       search,
+			ListingID:    int64(l.ID),
+			Address:      l.Address,
+			Suburb:       l.Suburb,
+			State:        l.State,
+			Postcode:     l.Postcode,
+			PropertyType: l.PropertyType,
+			Bedrooms:     l.Bedrooms,
+			Bathrooms:    l.Bathrooms,
+			Carspaces:    l.Carspaces,
+			DisplayPrice: l.DisplayPrice,
+			Price:        l.Price,
+			DateListed:   l.DateListed,
+			URL:          l.URL,
+		}
+	}
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// parquetHandler serves the listings found by a search as a Parquet file.
+func (dc domainCollector) parquetHandler(w http.ResponseWriter, r *http.Request) {
+	module, rsr, err := dc.searchFromQuery(r.URL.Query())
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	listings, err := dc.search(r.Context(), module, rsr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error searching domain: %v", err), http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]listingSummary, len(listings))
+	for i, l := range listings {
+		summaries[i] = summarize(l)
+	}
+	var b bytes.Buffer
+	if err := writeParquet(&b, searchKey(module, rsr), time.Now(), summaries); err != nil {
+		http.Error(w, fmt.Sprintf("error writing parquet: %v", err), http.StatusInternalServerError)
+		return
+	}
+	name := module
+	if name == "" {
+		name = "listings"
+	}
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".parquet"))
+	w.Write(b.Bytes())
+}
+
+// parquetSink writes a Parquet file of every search's results to a local
+// directory, S3 bucket or GCS bucket, at
+// <dest>/<search>/<date>/<time>.parquet.
+type parquetSink struct {
+	dest   *url.URL
+	writes *prometheus.CounterVec
+}
+
+func newParquetSink(dest string) (*parquetSink, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "":
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return nil, err
+		}
+	case "s3", "gs":
+	default:
+		return nil, fmt.Errorf("unsupported destination %q, want a directory, s3://<bucket>/<prefix> or gs://<bucket>/<prefix>", dest)
+	}
+	s := &parquetSink{
+		dest: u,
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_parquet_writes_total",
+			Help: "Number of Parquet snapshots written, by whether they were written.",
+		}, []string{"result"}),
+	}
+	s.writes.WithLabelValues("success")
+	s.writes.WithLabelValues("failure")
+	return s, nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// write writes a snapshot of listings in the background.
+func (s *parquetSink) write(search string, observed time.Time, listings []listingSummary) {
+	if s == nil {
+		return
+	}
+	go func() {
+		var b bytes.Buffer
+		err := writeParquet(&b, search, observed, listings)
+		if err == nil {
+			name := path.Join(unsafePathChars.ReplaceAllString(search, "_"), observed.UTC().Format("2006-01-02"), observed.UTC().Format("150405.000")+".parquet")
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = s.put(ctx, name, b.Bytes())
+			cancel()
+		}
+		if err != nil {
+			s.writes.WithLabelValues("failure").Inc()
+			slog.Error("error writing parquet snapshot", "search", search, "err", secrets.redact(err.Error()))
+			return
+		}
+		s.writes.WithLabelValues("success").Inc()
+	}()
+}
+
+func (s *parquetSink) put(ctx context.Context, name string, data []byte) error {
+	switch s.dest.Scheme {
+	case "s3":
+		return putS3(ctx, s.dest.Host, path.Join(strings.TrimPrefix(s.dest.Path, "/"), name), data)
+	case "gs":
+		return putGCS(ctx, s.dest.Host, path.Join(strings.TrimPrefix(s.dest.Path, "/"), name), data)
+	}
+	p := filepath.Join(s.dest.Path, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so readers never see half a file.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// putS3 uploads an object to S3, with credentials from the same environment
+// variables as awssm:// secrets.
+func putS3(ctx context.Context, bucket, key string, data []byte) error {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || keyID == "" || secret == "" {
+		return fmt.Errorf("$AWS_REGION, $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY must be set to write to s3://")
+	}
+	host := bucket + ".s3." + region + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, "PUT", "https://"+host+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.apache.parquet")
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(data))
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSv4(req, data, host, region, "s3", keyID, secret, time.Now().UTC())
+	return doUpload(req)
+}
+
+// putGCS uploads an object to Google Cloud Storage, using Application Default
+// Credentials.
+func putGCS(ctx context.Context, bucket, name string, data []byte) error {
+	c, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s", url.PathEscape(bucket), url.QueryEscape(name))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.apache.parquet")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkUpload(resp)
+}
+
+func doUpload(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkUpload(resp)
+}
+
+func checkUpload(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload to %s: %s: %s", resp.Request.URL.Host, resp.Status, b)
+	}
+	return nil
+}
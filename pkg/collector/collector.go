@@ -0,0 +1,198 @@
+// Package collector exports domain.com.au listing search results as
+// Prometheus metrics, for embedding in other Go programs.
+//
+// A Collector runs a search every time it's collected, and exports
+// domain_listing_count and domain_listing_median_price for each group of
+// listings with the same property type, suburb, postcode, bedrooms,
+// bathrooms and car spaces:
+//
+//	c := collector.NewCollector(collector.Options{
+//		Client: domain.NewClient(http.DefaultClient, apiKey),
+//		Search: domain.ResidentialSearchRequest{
+//			ListingType: "Rent",
+//			Locations:   []domain.LocationFilter{{State: "NSW", Suburb: "Pyrmont"}},
+//		},
+//	})
+//	prometheus.MustRegister(c)
+//
+// Free API keys only allow 500 calls a day, so collect it rarely.
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels are the label names of the listing metrics, in the order of
+// Group.Labels.
+var Labels = []string{"propertytype", "suburb", "postcode", "bedrooms", "bathrooms", "carspaces"}
+
+var (
+	listingCountDesc = prometheus.NewDesc(
+		"domain_listing_count",
+		"Number of listings found by the search.",
+		Labels, nil,
+	)
+	medianPriceDesc = prometheus.NewDesc(
+		"domain_listing_median_price",
+		"Median price of listings, from their price or the first dollar amount in their display price.",
+		Labels, nil,
+	)
+)
+
+// Options configure a Collector.
+type Options struct {
+	// Client runs the search. Required.
+	Client *domain.Client
+	// Search is the search to run on every collection.
+	Search domain.ResidentialSearchRequest
+	// MaxSeries is the most groups to export, or 0 for no limit. Groups past
+	// the limit, in label order, are left out.
+	MaxSeries int
+}
+
+// Collector is a prometheus.Collector that runs a search when collected.
+type Collector struct {
+	opts Options
+}
+
+// NewCollector returns a Collector for opts.
+func NewCollector(opts Options) *Collector {
+	return &Collector{opts: opts}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listingCountDesc
+	ch <- medianPriceDesc
+}
+
+// Collect implements prometheus.Collector. If the search fails, it exports
+// an invalid metric, which fails the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	listings, err := c.opts.Client.SearchResidential(c.opts.Search)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(listingCountDesc, fmt.Errorf("error searching domain: %v", err))
+		return
+	}
+	groups := GroupListings(listings)
+	if c.opts.MaxSeries > 0 && len(groups) > c.opts.MaxSeries {
+		groups = groups[:c.opts.MaxSeries]
+	}
+	collectGroups(ch, groups)
+}
+
+// groupsCollector exports groups that have already been counted.
+type groupsCollector []Group
+
+// NewGroupsCollector returns a collector of the metrics for groups, for
+// programs that run searches themselves.
+func NewGroupsCollector(groups []Group) prometheus.Collector {
+	return groupsCollector(groups)
+}
+
+// Describe implements prometheus.Collector.
+func (gc groupsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listingCountDesc
+	ch <- medianPriceDesc
+}
+
+// Collect implements prometheus.Collector.
+func (gc groupsCollector) Collect(ch chan<- prometheus.Metric) {
+	collectGroups(ch, gc)
+}
+
+func collectGroups(ch chan<- prometheus.Metric, groups []Group) {
+	for _, g := range groups {
+		ch <- prometheus.MustNewConstMetric(listingCountDesc, prometheus.GaugeValue, g.Count, g.Labels[:]...)
+		if g.MedianPrice != 0 {
+			ch <- prometheus.MustNewConstMetric(medianPriceDesc, prometheus.GaugeValue, g.MedianPrice, g.Labels[:]...)
+		}
+	}
+}
+
+// Group counts the listings that share the same Labels.
+type Group struct {
+	Labels [6]string
+	Count  float64
+	// MedianPrice is the median price of the listings with a price, or 0 if
+	// none of them have one.
+	MedianPrice float64
+}
+
+// GroupListings counts listings by Labels. Groups are sorted by their label
+// values, so they come out in the same order every time.
+func GroupListings(listings []domain.SearchResult) []Group {
+	counts := map[[6]string]float64{}
+	prices := map[[6]string][]float64{}
+	for _, l := range listings {
+		k := [6]string{
+			l.Listing.PropertyDetails.PropertyType,
+			l.Listing.PropertyDetails.Suburb,
+			l.Listing.PropertyDetails.Postcode,
+			fmt.Sprintf("%.1f", l.Listing.PropertyDetails.Bedrooms),
+			fmt.Sprintf("%.1f", l.Listing.PropertyDetails.Bathrooms),
+			fmt.Sprintf("%v", l.Listing.PropertyDetails.CarSpaces),
+		}
+		counts[k]++
+		if p := ParsePrice(l.Listing.PriceDetails); p != 0 {
+			prices[k] = append(prices[k], p)
+		}
+	}
+	groups := make([]Group, 0, len(counts))
+	for k, n := range counts {
+		groups = append(groups, Group{k, n, Median(prices[k])})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		for n := range groups[i].Labels {
+			if groups[i].Labels[n] != groups[j].Labels[n] {
+				return groups[i].Labels[n] < groups[j].Labels[n]
+			}
+		}
+		return false
+	})
+	return groups
+}
+
+// Median returns the median of xs, or 0 if it's empty. It sorts xs.
+func Median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sort.Float64s(xs)
+	if len(xs)%2 == 1 {
+		return xs[len(xs)/2]
+	}
+	return (xs[len(xs)/2-1] + xs[len(xs)/2]) / 2
+}
+
+var priceRE = regexp.MustCompile(`\$\s*([0-9][0-9,]*(?:\.[0-9]+)?)(?i:\s*(k|m|mil|million)\b)?`)
+
+// priceMultipliers are what the suffixes of display prices, like "$850k" and
+// "$1.2m", multiply the amount by.
+var priceMultipliers = map[string]float64{"": 1, "k": 1e3, "m": 1e6, "mil": 1e6, "million": 1e6}
+
+// ParsePrice returns the price of a listing. Agents often leave out the
+// numeric price and only fill in the display price, like "$650 per week" or
+// "Offers over $1.2m", so the first dollar amount in that is used instead. It
+// returns 0 if there is no price.
+func ParsePrice(p domain.PriceDetails) float64 {
+	if p.Price != 0 {
+		return float64(p.Price)
+	}
+	m := priceRE.FindStringSubmatch(p.DisplayPrice)
+	if m == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	return f * priceMultipliers[strings.ToLower(m[2])]
+}
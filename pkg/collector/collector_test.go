@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func listing(propertyType, suburb string, bedrooms float32, price int32) domain.SearchResult {
+	var l domain.SearchResult
+	l.Listing.PropertyDetails.PropertyType = propertyType
+	l.Listing.PropertyDetails.Suburb = suburb
+	l.Listing.PropertyDetails.Postcode = "2009"
+	l.Listing.PropertyDetails.Bedrooms = bedrooms
+	l.Listing.PropertyDetails.Bathrooms = 1
+	l.Listing.PropertyDetails.CarSpaces = 1
+	l.Listing.PriceDetails.Price = price
+	return l
+}
+
+func TestGroupListings(t *testing.T) {
+	groups := GroupListings([]domain.SearchResult{
+		listing("Unit", "Pyrmont", 2, 700),
+		listing("House", "Pyrmont", 3, 1200),
+		listing("Unit", "Pyrmont", 2, 600),
+		listing("Unit", "Pyrmont", 1, 500),
+		listing("Unit", "Pyrmont", 2, 0),
+		listing("Unit", "Pyrmont", 2, 650),
+		listing("Townhouse", "Pyrmont", 3, 0),
+	})
+	want := []Group{
+		{Labels: [6]string{"House", "Pyrmont", "2009", "3.0", "1.0", "1"}, Count: 1, MedianPrice: 1200},
+		{Labels: [6]string{"Townhouse", "Pyrmont", "2009", "3.0", "1.0", "1"}, Count: 1},
+		{Labels: [6]string{"Unit", "Pyrmont", "2009", "1.0", "1.0", "1"}, Count: 1, MedianPrice: 500},
+		// Listings without a price count, but don't drag the median down.
+		{Labels: [6]string{"Unit", "Pyrmont", "2009", "2.0", "1.0", "1"}, Count: 4, MedianPrice: 650},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("GroupListings() =\n%+v\nwant\n%+v", groups, want)
+	}
+	if groups := GroupListings(nil); len(groups) != 0 {
+		t.Errorf("GroupListings(nil) = %+v, want none", groups)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	for _, tc := range []struct {
+		xs   []float64
+		want float64
+	}{
+		{xs: nil, want: 0},
+		{xs: []float64{5}, want: 5},
+		{xs: []float64{3, 1, 2}, want: 2},
+		{xs: []float64{4, 1, 3, 2}, want: 2.5},
+		{xs: []float64{650, 650, 700, 600}, want: 650},
+	} {
+		if got := Median(append([]float64(nil), tc.xs...)); got != tc.want {
+			t.Errorf("Median(%v) = %v, want %v", tc.xs, got, tc.want)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// fakeClient returns a domain.Client whose searches get status and body.
+func fakeClient(status int, body string) *domain.Client {
+	return domain.NewClient(&http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}, "key")
+}
+
+func TestCollector(t *testing.T) {
+	listings, err := json.Marshal([]domain.SearchResult{
+		listing("Unit", "Pyrmont", 2, 700),
+		listing("Unit", "Pyrmont", 2, 600),
+		listing("House", "Pyrmont", 3, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCollector(Options{Client: fakeClient(200, string(listings))})
+	if err := testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP domain_listing_count Number of listings found by the search.
+# TYPE domain_listing_count gauge
+domain_listing_count{bathrooms="1.0",bedrooms="2.0",carspaces="1",postcode="2009",propertytype="Unit",suburb="Pyrmont"} 2
+domain_listing_count{bathrooms="1.0",bedrooms="3.0",carspaces="1",postcode="2009",propertytype="House",suburb="Pyrmont"} 1
+# HELP domain_listing_median_price Median price of listings, from their price or the first dollar amount in their display price.
+# TYPE domain_listing_median_price gauge
+domain_listing_median_price{bathrooms="1.0",bedrooms="2.0",carspaces="1",postcode="2009",propertytype="Unit",suburb="Pyrmont"} 650
+`)); err != nil {
+		t.Error(err)
+	}
+
+	c = NewCollector(Options{Client: fakeClient(200, string(listings)), MaxSeries: 1})
+	if n := testutil.CollectAndCount(c, "domain_listing_count"); n != 1 {
+		t.Errorf("exported %d domain_listing_count series with MaxSeries 1, want 1", n)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(Options{Client: fakeClient(500, "oops")}))
+	if _, err := reg.Gather(); err == nil || !strings.Contains(err.Error(), "error searching domain") {
+		t.Errorf("Gather() = %v, want the search's error", err)
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	for _, tc := range []struct {
+		price   int32
+		display string
+		want    float64
+	}{
+		{price: 650, display: "$700 per week", want: 650},
+		{display: "$650 per week", want: 650},
+		{display: "$650pw", want: 650},
+		{display: "Offers over $1,250,000", want: 1250000},
+		{display: "$1.2m", want: 1200000},
+		{display: "$1.2M", want: 1200000},
+		{display: "Offers over $1.2 million", want: 1200000},
+		{display: "$1.5 mil", want: 1500000},
+		{display: "$850k", want: 850000},
+		{display: "$850K - $900K", want: 850000},
+		{display: "$850 kitchen included", want: 850},
+		{display: "$650 modern", want: 650},
+		{display: "Contact agent", want: 0},
+		{display: "Auction", want: 0},
+		{display: "", want: 0},
+	} {
+		if got := ParsePrice(domain.PriceDetails{Price: tc.price, DisplayPrice: tc.display}); got != tc.want {
+			t.Errorf("ParsePrice(%d, %q) = %v, want %v", tc.price, tc.display, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package listingspb is the gRPC API of domain_exporter, enabled with
+// --grpc.listen.
+package listingspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative listings.proto
@@ -0,0 +1,186 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: listings.proto
+
+package listingspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Listings_Query_FullMethodName       = "/domain_exporter.v1.Listings/Query"
+	Listings_WatchEvents_FullMethodName = "/domain_exporter.v1.Listings/WatchEvents"
+)
+
+// ListingsClient is the client API for Listings service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Listings runs searches and streams changes to the listings they find.
+type ListingsClient interface {
+	// Query runs a search, like /api/v1/listings.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	// WatchEvents streams listing events as searches find them, until the
+	// client cancels.
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Listings_WatchEventsClient, error)
+}
+
+type listingsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewListingsClient(cc grpc.ClientConnInterface) ListingsClient {
+	return &listingsClient{cc}
+}
+
+func (c *listingsClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, Listings_Query_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *listingsClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (Listings_WatchEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Listings_ServiceDesc.Streams[0], Listings_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &listingsWatchEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Listings_WatchEventsClient interface {
+	Recv() (*ListingEvent, error)
+	grpc.ClientStream
+}
+
+type listingsWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *listingsWatchEventsClient) Recv() (*ListingEvent, error) {
+	m := new(ListingEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ListingsServer is the server API for Listings service.
+// All implementations must embed UnimplementedListingsServer
+// for forward compatibility
+//
+// Listings runs searches and streams changes to the listings they find.
+type ListingsServer interface {
+	// Query runs a search, like /api/v1/listings.
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	// WatchEvents streams listing events as searches find them, until the
+	// client cancels.
+	WatchEvents(*WatchEventsRequest, Listings_WatchEventsServer) error
+	mustEmbedUnimplementedListingsServer()
+}
+
+// UnimplementedListingsServer must be embedded to have forward compatible implementations.
+type UnimplementedListingsServer struct {
+}
+
+func (UnimplementedListingsServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedListingsServer) WatchEvents(*WatchEventsRequest, Listings_WatchEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedListingsServer) mustEmbedUnimplementedListingsServer() {}
+
+// UnsafeListingsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ListingsServer will
+// result in compilation errors.
+type UnsafeListingsServer interface {
+	mustEmbedUnimplementedListingsServer()
+}
+
+func RegisterListingsServer(s grpc.ServiceRegistrar, srv ListingsServer) {
+	s.RegisterService(&Listings_ServiceDesc, srv)
+}
+
+func _Listings_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ListingsServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Listings_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ListingsServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Listings_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ListingsServer).WatchEvents(m, &listingsWatchEventsServer{ServerStream: stream})
+}
+
+type Listings_WatchEventsServer interface {
+	Send(*ListingEvent) error
+	grpc.ServerStream
+}
+
+type listingsWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *listingsWatchEventsServer) Send(m *ListingEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Listings_ServiceDesc is the grpc.ServiceDesc for Listings service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Listings_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "domain_exporter.v1.Listings",
+	HandlerType: (*ListingsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _Listings_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Listings_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "listings.proto",
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probePhases are the phases of Domain API requests timed by probeTrace, named
+// like the blackbox exporter's.
+var probePhases = []string{"resolve", "connect", "tls", "processing", "transfer"}
+
+var (
+	probeSuccessDesc = prometheus.NewDesc(
+		"probe_success",
+		"Whether the search succeeded, 1 if it did.",
+		nil, nil,
+	)
+	probeDurationDesc = prometheus.NewDesc(
+		"probe_duration_seconds",
+		"How long the search took, in seconds.",
+		nil, nil,
+	)
+	probeHTTPDurationDesc = prometheus.NewDesc(
+		"probe_http_duration_seconds",
+		"Time spent in each phase of the search's Domain API requests, in seconds, summed over requests.",
+		[]string{"phase"}, nil,
+	)
+)
+
+// probeTrace times the phases of the Domain API requests made by a search,
+// for blackbox exporter style metrics on /listings, so blackbox alerting
+// rules work with it unchanged.
+type probeTrace struct {
+	mu        sync.Mutex
+	phases    map[string]time.Duration
+	starts    map[string]time.Time
+	firstByte time.Time // Of the request whose body is being read, if any.
+}
+
+func newProbeTrace() *probeTrace {
+	return &probeTrace{phases: map[string]time.Duration{}, starts: map[string]time.Time{}}
+}
+
+func (p *probeTrace) start(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starts[phase] = time.Now()
+}
+
+func (p *probeTrace) end(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.starts[phase]; ok {
+		p.phases[phase] += time.Since(t)
+		delete(p.starts, phase)
+	}
+}
+
+// endTransfer ends the transfer of the last response, which lasts until the
+// next request or the end of the search.
+func (p *probeTrace) endTransfer() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.firstByte.IsZero() {
+		p.phases["transfer"] += time.Since(p.firstByte)
+		p.firstByte = time.Time{}
+	}
+}
+
+func (p *probeTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:           func(string) { p.endTransfer() },
+		DNSStart:          func(httptrace.DNSStartInfo) { p.start("resolve") },
+		DNSDone:           func(httptrace.DNSDoneInfo) { p.end("resolve") },
+		ConnectStart:      func(string, string) { p.start("connect") },
+		ConnectDone:       func(string, string, error) { p.end("connect") },
+		TLSHandshakeStart: func() { p.start("tls") },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { p.end("tls") },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { p.start("processing") },
+		GotFirstResponseByte: func() {
+			p.end("processing")
+			p.mu.Lock()
+			p.firstByte = time.Now()
+			p.mu.Unlock()
+		},
+	}
+}
+
+// result returns the probe metrics of a search that took duration.
+func (p *probeTrace) result(success bool, duration time.Duration) probeResult {
+	p.endTransfer()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := probeResult{success: success, duration: duration, phases: map[string]time.Duration{}}
+	for phase, d := range p.phases {
+		r.phases[phase] = d
+	}
+	return r
+}
+
+// probeResult exports how a search went as probe metrics.
+type probeResult struct {
+	success  bool
+	duration time.Duration
+	phases   map[string]time.Duration
+}
+
+// Describe implements prometheus.Collector.
+func (r probeResult) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeSuccessDesc
+	ch <- probeDurationDesc
+	ch <- probeHTTPDurationDesc
+}
+
+// Collect implements prometheus.Collector.
+func (r probeResult) Collect(ch chan<- prometheus.Metric) {
+	s := 0.0
+	if r.success {
+		s = 1
+	}
+	ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, s)
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, r.duration.Seconds())
+	for _, phase := range probePhases {
+		ch <- prometheus.MustNewConstMetric(probeHTTPDurationDesc, prometheus.GaugeValue, r.phases[phase].Seconds(), phase)
+	}
+}
+
+// probeHandler serves /listings like the blackbox exporter serves /probe: the
+// search's metrics and probe metrics if it succeeded, and only the probe
+// metrics, with probe_success 0, if it didn't.
+func (dc domainCollector) probeHandler(w http.ResponseWriter, r *http.Request, module string, rsr domain.ResidentialSearchRequest) {
+	start := time.Now()
+	p := newProbeTrace()
+	reg, err := dc.listingsRegistry(httptrace.WithClientTrace(r.Context(), p.clientTrace()), module, rsr)
+	if err != nil {
+		// dc.search has logged the error.
+		reg = prometheus.NewPedanticRegistry()
+	}
+	reg.MustRegister(p.result(err == nil, time.Since(start)))
+	promhttp.HandlerFor(reg, openMetricsOpts).ServeHTTP(w, r)
+}
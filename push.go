@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pusher sends the metrics of one search somewhere, for when the exporter
+// can't be scraped.
+type pusher interface {
+	Push(ctx context.Context, module string, g prometheus.Gatherer) error
+}
+
+// pushers runs searches and pushes their metrics to every pusher.
+type pushers struct {
+	dc domainCollector
+	// modules are the modules to push, or nil for all of them, as they are
+	// each time, so reloads and the search API change what's pushed.
+	modules []string
+	pushers map[string]pusher
+	pushes  *prometheus.CounterVec
+	// jitter is the most each push is delayed by at random, so exporters
+	// pushing the same modules on the same interval don't all search at
+	// once.
+	jitter time.Duration
+
+	mu       sync.Mutex
+	schedule map[string]pushSchedule
+}
+
+func newPushers(dc domainCollector, modules []string, ps map[string]pusher) *pushers {
+	p := &pushers{
+		dc:       dc,
+		modules:  modules,
+		pushers:  ps,
+		schedule: map[string]pushSchedule{},
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_pushes_total",
+			Help: "Number of searches pushed by each pusher, by whether they were pushed.",
+		}, []string{"pusher", "result"}),
+	}
+	for name := range ps {
+		p.pushes.WithLabelValues(name, "success")
+		p.pushes.WithLabelValues(name, "failure")
+	}
+	return p
+}
+
+// pushOnce runs every search and pushes its results, returning an error if
+// any search or push failed.
+func (p *pushers) pushOnce(ctx context.Context) error {
+	ctx = withTrigger(ctx, "push")
+	failed := 0
+	for _, module := range p.current() {
+		failed += p.pushModule(ctx, module)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d searches or pushes failed", failed)
+	}
+	return nil
+}
+
+// pushModule runs a search and pushes its results, returning how many of the
+// search and pushes failed. Replicas that aren't the leader don't push.
+func (p *pushers) pushModule(ctx context.Context, module string) int {
+	if !p.dc.leader.isLeading() {
+		return 0
+	}
+	failed := 0
+	defer func() { p.ran(module, time.Now(), failed) }()
+	rsr, ok := p.dc.searches.get(module)
+	if !ok {
+		slog.Error("unknown module", "module", module)
+		failed++
+		return failed
+	}
+	reg, err := p.dc.listingsRegistry(ctx, module, rsr)
+	if err != nil {
+		failed++
+		return failed
+	}
+	for name, ps := range p.pushers {
+		if err := ps.Push(ctx, module, reg); err != nil {
+			p.pushes.WithLabelValues(name, "failure").Inc()
+			slog.Error("error pushing metrics", "pusher", name, "module", module, "err", secrets.redact(err.Error()))
+			failed++
+			continue
+		}
+		p.pushes.WithLabelValues(name, "success").Inc()
+	}
+	return failed
+}
+
+// run pushes every interval until ctx is done, or the exporter is quiescing.
+// With stagger, each module is pushed at its own time in the interval rather
+// than all at once, see runStaggered. Either way, pushes are delayed by up to
+// p.jitter.
+func (p *pushers) run(ctx context.Context, interval time.Duration, stagger bool) {
+	if stagger {
+		p.runStaggered(ctx, interval)
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for !p.dc.health.quiescing() {
+		if !sleepCtx(ctx, p.jittered()) {
+			return
+		}
+		next := time.Now().Add(interval)
+		for _, module := range p.current() {
+			p.scheduled(module, next)
+		}
+		p.pushOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runStaggered pushes each module every interval, at an offset into it from
+// a hash of the module's name, so a hundred modules make a hundred Domain API
+// calls spread over the interval rather than in the same second. Offsets are
+// from the wall clock, so modules keep their times across restarts, and
+// replicas agree on them.
+func (p *pushers) runStaggered(ctx context.Context, interval time.Duration) {
+	ctx = withTrigger(ctx, "push")
+	next := map[string]time.Time{}
+	for !p.dc.health.quiescing() {
+		now := time.Now()
+		modules := p.current()
+		for module := range next {
+			if !slices.Contains(modules, module) {
+				delete(next, module)
+			}
+		}
+		due := ""
+		for _, module := range modules {
+			if _, ok := next[module]; !ok {
+				next[module] = nextSlot(module, interval, now).Add(p.jittered())
+				p.scheduled(module, next[module])
+			}
+			if due == "" || next[module].Before(next[due]) {
+				due = module
+			}
+		}
+		if due != "" && !next[due].After(now) {
+			next[due] = nextSlot(due, interval, now).Add(p.jittered())
+			p.scheduled(due, next[due])
+			p.pushModule(ctx, due)
+			continue
+		}
+		// Woken up now and then, to pick up modules added in the meantime.
+		wait := pushRecheck
+		if due != "" && next[due].Sub(now) < wait {
+			wait = next[due].Sub(now)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pushRecheck is how often runStaggered looks for new modules.
+const pushRecheck = time.Minute
+
+// jittered returns a random delay of up to p.jitter.
+func (p *pushers) jittered() time.Duration {
+	if p.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(p.jitter)))
+}
+
+// sleepCtx waits for d, returning false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// nextSlot returns the first time after t that module is due, every interval
+// at an offset into it from a hash of its name.
+func nextSlot(module string, interval time.Duration, t time.Time) time.Time {
+	h := fnv.New64a()
+	h.Write([]byte(module))
+	// The low bits, which FNV mixes best for similar names.
+	offset := time.Duration(h.Sum64() % uint64(interval))
+	slot := t.Truncate(interval).Add(offset)
+	if !slot.After(t) {
+		slot = slot.Add(interval)
+	}
+	return slot
+}
+
+// pushSchedule is when a module was last pushed, and will be next.
+type pushSchedule struct {
+	Module string
+	Next   time.Time
+	Last   time.Time
+	Failed int // Of the search and pushes, the last time.
+}
+
+// current returns the modules to push now, forgetting the schedule of any
+// that have gone.
+func (p *pushers) current() []string {
+	modules := p.modules
+	if modules == nil {
+		modules = p.dc.searches.names()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for module := range p.schedule {
+		if !slices.Contains(modules, module) {
+			delete(p.schedule, module)
+		}
+	}
+	return modules
+}
+
+// scheduled records when a module will next be pushed.
+func (p *pushers) scheduled(module string, next time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.schedule[module]
+	s.Module, s.Next = module, next
+	p.schedule[module] = s
+}
+
+// ran records that a module was pushed.
+func (p *pushers) ran(module string, at time.Time, failed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.schedule[module]
+	s.Module, s.Last, s.Failed = module, at, failed
+	p.schedule[module] = s
+}
+
+var debugScheduleTemplate = template.Must(template.New("schedule").Parse(`<!doctype html>
+<title>Push schedule</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; }
+</style>
+<h1>Push schedule</h1>
+<p>Modules are pushed every {{.Interval}}{{if .Stagger}}, each at its own time in the interval{{else}}, all at once{{end}}.</p>
+<table>
+<tr><th>Module</th><th>Next</th><th>Last</th><th>Result</th></tr>
+{{range .Modules -}}
+<tr><td>{{.Module}}</td><td>{{if not .Next.IsZero}}{{.Next.Format "15:04:05"}}{{end}}</td><td>{{if not .Last.IsZero}}{{.Last.Format "15:04:05"}}{{end}}</td><td>{{if .Last.IsZero}}{{else if .Failed}}{{.Failed}} failed{{else}}ok{{end}}</td></tr>
+{{end -}}
+</table>
+`))
+
+// debugScheduleHandler shows when each module was last pushed, and when it
+// will be next, soonest first.
+func (p *pushers) debugScheduleHandler(interval time.Duration, stagger bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		modules := make([]pushSchedule, 0, len(p.schedule))
+		for _, s := range p.schedule {
+			modules = append(modules, s)
+		}
+		p.mu.Unlock()
+		sort.Slice(modules, func(i, j int) bool {
+			if !modules[i].Next.Equal(modules[j].Next) {
+				return modules[i].Next.Before(modules[j].Next)
+			}
+			return modules[i].Module < modules[j].Module
+		})
+		data := struct {
+			Interval time.Duration
+			Stagger  bool
+			Modules  []pushSchedule
+		}{interval, stagger, modules}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugScheduleTemplate.Execute(w, data); err != nil {
+			slog.Error("couldn't render schedule", "err", err)
+		}
+	}
+}
+
+// pushgateway pushes to a Prometheus Pushgateway, grouped by module, so each
+// search replaces only its own metrics.
+type pushgateway struct {
+	url *url.URL
+	job string
+}
+
+func newPushgateway(u, job string) (*pushgateway, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	return &pushgateway{url: pu, job: job}, nil
+}
+
+// Push implements pusher.
+func (pg *pushgateway) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	u := *pg.url
+	u.User = nil
+	p := push.New(u.String(), pg.job).Grouping("module", module).Gatherer(g)
+	if pg.url.User != nil {
+		password, _ := pg.url.User.Password()
+		p = p.BasicAuth(pg.url.User.Username(), password)
+	}
+	return p.PushContext(ctx)
+}
+
+// pushSample is one sample of a gauge, counter or untyped metric.
+type pushSample struct {
+	Name   string
+	Labels []labelPair // Sorted by name.
+	Value  float64
+	// Counter is whether it's a counter, which started counting at Created,
+	// if that's known.
+	Counter bool
+	Created time.Time
+}
+
+type labelPair struct {
+	Name, Value string
+}
+
+// gatherSamples gathers the gauges, counters and untyped metrics from g, with
+// extra labels added to each. Summaries and histograms are left out.
+func gatherSamples(g prometheus.Gatherer, extra map[string]string) ([]pushSample, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var samples []pushSample
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			s := pushSample{Name: mf.GetName()}
+			switch {
+			case m.Gauge != nil:
+				s.Value = m.Gauge.GetValue()
+			case m.Counter != nil:
+				s.Value = m.Counter.GetValue()
+				s.Counter = true
+				if ct := m.Counter.GetCreatedTimestamp(); ct != nil {
+					s.Created = ct.AsTime()
+				}
+			case m.Untyped != nil:
+				s.Value = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			for k, v := range extra {
+				s.Labels = append(s.Labels, labelPair{k, v})
+			}
+			for _, l := range m.Label {
+				s.Labels = append(s.Labels, labelPair{l.GetName(), l.GetValue()})
+			}
+			sort.Slice(s.Labels, func(i, j int) bool { return s.Labels[i].Name < s.Labels[j].Name })
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextSlot(t *testing.T) {
+	interval := time.Hour
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	offsets := map[time.Duration]bool{}
+	for _, module := range []string{"pyrmont", "ultimo", "glebe", "newtown", "pyrmont-2br", "pyrmont-3br"} {
+		first := nextSlot(module, interval, start)
+		if !first.After(start) || first.Sub(start) > interval {
+			t.Errorf("nextSlot(%q, %v) = %v, want within an interval after", module, start, first)
+		}
+		offsets[first.Sub(start)] = true
+		for _, tc := range []struct {
+			name string
+			t    time.Time
+			want time.Time
+		}{
+			{name: "just before", t: first.Add(-time.Nanosecond), want: first},
+			{name: "at the slot", t: first, want: first.Add(interval)},
+			{name: "just after", t: first.Add(time.Second), want: first.Add(interval)},
+			{name: "a day later", t: first.Add(24*time.Hour - time.Minute), want: first.Add(24 * time.Hour)},
+		} {
+			if got := nextSlot(module, interval, tc.t); !got.Equal(tc.want) {
+				t.Errorf("%s: nextSlot(%q, %v) = %v, want %v", tc.name, module, tc.t, got, tc.want)
+			}
+		}
+	}
+	// Similar names should still be spread out.
+	if len(offsets) < 5 {
+		t.Errorf("6 modules got only %d different offsets", len(offsets))
+	}
+}
+
+func TestJittered(t *testing.T) {
+	p := &pushers{}
+	if got := p.jittered(); got != 0 {
+		t.Errorf("jittered() = %v without jitter, want 0", got)
+	}
+	p.jitter = time.Second
+	for i := 0; i < 100; i++ {
+		if got := p.jittered(); got < 0 || got >= p.jitter {
+			t.Fatalf("jittered() = %v, want in [0, %v)", got, p.jitter)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// rateLimitIdle is how long a client's limiter is kept after its last
+// request. By then its bucket is full again, so forgetting it changes nothing.
+const rateLimitIdle = 10 * time.Minute
+
+// rateLimitMaxClients caps how many clients have their own limiter, so lots of
+// addresses can't use up memory. Clients beyond it share one.
+const rateLimitMaxClients = 10000
+
+// rateLimitOverflow is the client sharing a limiter once there are
+// rateLimitMaxClients.
+const rateLimitOverflow = "overflow"
+
+// rateLimits limits how often each client can use the endpoints that search
+// the Domain API, so a dashboard refreshing too often can't use up the daily
+// quota. Clients are told apart by their bearer token or basic auth if it's
+// one of auth's, or their IP address.
+type rateLimits struct {
+	rps   rate.Limit
+	burst int
+	auth  *httpAuth
+
+	mu       sync.Mutex
+	clients  map[string]*clientLimiter
+	lastTidy time.Time
+
+	limited prometheus.Counter
+}
+
+type clientLimiter struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// newRateLimits returns rateLimits allowing rps requests a second from each
+// client, in bursts of up to burst, or nil if rps is 0. Clients with auth's
+// credentials are limited by credential, and others by IP address.
+func newRateLimits(rps float64, burst int, auth *httpAuth) *rateLimits {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimits{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		auth:    auth,
+		clients: map[string]*clientLimiter{},
+		limited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "domain_http_rate_limited_total",
+			Help: "Number of requests to endpoints that search rejected because their client was over --web.search-rate-limit.",
+		}),
+	}
+}
+
+// reserve takes n requests from a client's bucket, returning how long it
+// should wait to retry if there aren't enough.
+func (l *rateLimits) reserve(client string, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastTidy) > time.Minute {
+		for k, c := range l.clients {
+			if now.Sub(c.seen) > rateLimitIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastTidy = now
+	}
+	c, ok := l.clients[client]
+	if !ok && len(l.clients) >= rateLimitMaxClients {
+		client = rateLimitOverflow
+		c, ok = l.clients[client]
+	}
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[client] = c
+	}
+	c.seen = now
+	r := c.limiter.ReserveN(now, n)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+// clientKey identifies the client making a request, hashing credentials so
+// they aren't kept. Only credentials auth accepts count, since anyone can make
+// up a new Authorization header for every request to get a new limiter.
+func clientKey(auth *httpAuth, authorization, addr string) string {
+	if auth != nil && authorization != "" && auth.check(authorization) {
+		return fmt.Sprintf("auth:%x", sha256.Sum256([]byte(authorization)))
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + addr
+}
+
+// allow reports whether r's client is under its limit, responding 429 if it
+// isn't.
+func (l *rateLimits) allow(w http.ResponseWriter, r *http.Request) bool {
+	return l.allowN(w, r, 1)
+}
+
+// allowN is allow for a request that searches n times. n can't be more than
+// the burst.
+func (l *rateLimits) allowN(w http.ResponseWriter, r *http.Request, n int) bool {
+	if l == nil || n <= 0 {
+		return true
+	}
+	wait := l.reserve(clientKey(l.auth, r.Header.Get("Authorization"), r.RemoteAddr), n)
+	if wait == 0 {
+		return true
+	}
+	l.limited.Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many searches, slow down", http.StatusTooManyRequests)
+	return false
+}
+
+// allowGRPC reports whether the client of a gRPC call is under its limit.
+func (l *rateLimits) allowGRPC(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	var authorization, addr string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if l.reserve(clientKey(l.auth, authorization, addr), 1) == 0 {
+		return true
+	}
+	l.limited.Inc()
+	return false
+}
+
+// wrap returns h, rejecting requests from clients over their limit.
+func (l *rateLimits) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, r) {
+			h(w, r)
+		}
+	}
+}
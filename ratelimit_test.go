@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientKey(t *testing.T) {
+	auth := newHTTPAuth("searching", []string{"good"}, "user", "pass")
+	basic := "Basic dXNlcjpwYXNz" // user:pass
+	for _, tc := range []struct {
+		name          string
+		auth          *httpAuth
+		authorization string
+		addr          string
+		want          string
+	}{
+		{"no auth header", auth, "", "10.0.0.1:1234", "ip:10.0.0.1"},
+		{"good token", auth, "Bearer good", "10.0.0.1:1234", "auth:"},
+		{"good basic auth", auth, basic, "10.0.0.1:1234", "auth:"},
+		{"bad token", auth, "Bearer made-up", "10.0.0.1:1234", "ip:10.0.0.1"},
+		{"token without auth configured", nil, "Bearer made-up", "10.0.0.1:1234", "ip:10.0.0.1"},
+		{"IPv6", nil, "", "[2001:db8::1]:1234", "ip:2001:db8::1"},
+		{"address without port", nil, "", "10.0.0.1", "ip:10.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := clientKey(tc.auth, tc.authorization, tc.addr)
+			// Credentials are hashed, so only check they're used.
+			ok := got == tc.want
+			if tc.want == "auth:" {
+				ok = strings.HasPrefix(got, tc.want)
+			}
+			if !ok {
+				t.Errorf("clientKey(%q, %q) = %q, want %q", tc.authorization, tc.addr, got, tc.want)
+			}
+			if strings.Contains(got, "good") || strings.Contains(got, basic) {
+				t.Errorf("clientKey(%q, %q) = %q, which has the credentials in it", tc.authorization, tc.addr, got)
+			}
+		})
+	}
+	if a, b := clientKey(auth, "Bearer good", "10.0.0.1:1"), clientKey(auth, "Bearer good", "10.0.0.2:1"); a != b {
+		t.Errorf("the same token from different addresses got different keys %q and %q", a, b)
+	}
+}
+
+func TestRateLimitsAllow(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		auth *httpAuth
+		// headers are the Authorization headers of each request, all from
+		// the same address.
+		headers []string
+		want    []int
+	}{
+		{
+			name:    "burst then limited",
+			headers: []string{"", "", ""},
+			want:    []int{200, 200, 429},
+		},
+		{
+			name:    "made up credentials share the address's limit",
+			headers: []string{"Bearer a", "Bearer b", "Bearer c"},
+			want:    []int{200, 200, 429},
+		},
+		{
+			name:    "made up credentials share the address's limit with auth",
+			auth:    newHTTPAuth("searching", []string{"good"}, "", ""),
+			headers: []string{"Bearer a", "Bearer b", "Bearer c"},
+			want:    []int{200, 200, 429},
+		},
+		{
+			name:    "verified credentials get their own limit",
+			auth:    newHTTPAuth("searching", []string{"good", "other"}, "", ""),
+			headers: []string{"Bearer good", "Bearer good", "Bearer other", "Bearer other", "Bearer good"},
+			want:    []int{200, 200, 200, 200, 429},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newRateLimits(0.001, 2, tc.auth)
+			h := l.wrap(func(w http.ResponseWriter, r *http.Request) {})
+			for i, header := range tc.headers {
+				r := httptest.NewRequest("GET", "/listings", nil)
+				r.RemoteAddr = "10.0.0.1:1234"
+				if header != "" {
+					r.Header.Set("Authorization", header)
+				}
+				w := httptest.NewRecorder()
+				h(w, r)
+				if w.Code != tc.want[i] {
+					t.Errorf("request %d with %q got %d, want %d", i, header, w.Code, tc.want[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d got a 429 without Retry-After", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitsReserveN(t *testing.T) {
+	l := newRateLimits(0.001, 5, nil)
+	if wait := l.reserve("ip:a", 3); wait != 0 {
+		t.Fatalf("reserving 3 of 5 waited %v", wait)
+	}
+	if wait := l.reserve("ip:a", 3); wait == 0 {
+		t.Errorf("reserving 3 with 2 left didn't wait")
+	}
+	// A refused reservation doesn't take anything.
+	if wait := l.reserve("ip:a", 2); wait != 0 {
+		t.Errorf("reserving the 2 left waited %v", wait)
+	}
+}
+
+func TestRateLimitsMaxClients(t *testing.T) {
+	l := newRateLimits(0.001, 1, nil)
+	for i := 0; i < rateLimitMaxClients; i++ {
+		if wait := l.reserve(fmt.Sprintf("ip:%d", i), 1); wait != 0 {
+			t.Fatalf("client %d waited %v", i, wait)
+		}
+	}
+	if wait := l.reserve("ip:new", 1); wait != 0 {
+		t.Fatalf("first client past the cap waited %v", wait)
+	}
+	if wait := l.reserve("ip:newer", 1); wait == 0 {
+		t.Errorf("clients past the cap didn't share a limit")
+	}
+	if n := len(l.clients); n > rateLimitMaxClients+1 {
+		t.Errorf("%d clients tracked, want at most %d", n, rateLimitMaxClients+1)
+	}
+}
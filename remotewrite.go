@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriter pushes metrics with the Prometheus remote write protocol (1.0)
+// to Prometheus, Mimir, VictoriaMetrics and the like.
+// https://prometheus.io/docs/concepts/remote_write_spec/
+type remoteWriter struct {
+	url      string
+	job      string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newRemoteWriter(url, job, username, password, caFile, certFile, keyFile string, insecure bool) (*remoteWriter, error) {
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tc
+	return &remoteWriter{
+		url:      url,
+		job:      job,
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: t, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Push implements pusher.
+func (rw *remoteWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	samples, err := gatherSamples(g, map[string]string{"job": rw.job, "module": module})
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(samples, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, "POST", rw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "domain-exporter/"+version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if rw.username != "" {
+		req.SetBasicAuth(rw.username, rw.password)
+	}
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes samples as a prometheus.WriteRequest protobuf.
+func encodeWriteRequest(samples []pushSample, now time.Time) []byte {
+	ts := now.UnixMilli()
+	var req []byte
+	for _, s := range samples {
+		labels := append([]labelPair{{"__name__", s.Name}}, s.Labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		var series []byte
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
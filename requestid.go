@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+type triggerKey struct{}
+
+// maxRequestIDLen is the longest X-Request-Id header we'll take.
+const maxRequestIDLen = 64
+
+// withRequestID gives every request an ID, taken from its X-Request-Id header
+// if it has a valid one, so that logs and Domain API calls made while serving
+// it can be correlated.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-Id", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = withTrigger(ctx, r.Method+" "+r.URL.Path)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether a client's request ID is safe to log, echo
+// and send to the Domain API: short, and only letters, digits, ".", "_" and
+// "-".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request being served, or "" if none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withTrigger returns ctx saying what Domain API calls made with it are for,
+// like "GET /listings" for a request being served, or "push".
+func withTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// trigger returns what Domain API calls made with ctx are for, or "" if it
+// wasn't said.
+func trigger(ctx context.Context) string {
+	t, _ := ctx.Value(triggerKey{}).(string)
+	return t
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	for _, tc := range []struct {
+		name, header string
+		keep         bool
+	}{
+		{"none", "", false},
+		{"hex", "0123abcd", true},
+		{"uuid", "7c9e6679-7425-40de-944b-e07fc1f90ae7", true},
+		{"dots and underscores", "trace.span_1", true},
+		{"longest", strings.Repeat("a", maxRequestIDLen), true},
+		{"too long", strings.Repeat("a", maxRequestIDLen+1), false},
+		{"space", "a b", false},
+		{"newline", "a\nlevel=error", false},
+		{"quote", `a"b`, false},
+		{"unicode", "ሴ", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestID(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/listings", nil)
+			if tc.header != "" {
+				r.Header.Set("X-Request-Id", tc.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if tc.keep && got != tc.header {
+				t.Errorf("request ID = %q, want %q", got, tc.header)
+			}
+			if !tc.keep && (got == tc.header || !validRequestID(got)) {
+				t.Errorf("request ID = %q, want a new one", got)
+			}
+			if echoed := w.Header().Get("X-Request-Id"); echoed != got {
+				t.Errorf("X-Request-Id response header = %q, want %q", echoed, got)
+			}
+		})
+	}
+}
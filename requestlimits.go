@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requestLimits caps the size of requests, so an exporter exposed to the
+// internet doesn't spend memory and CPU on ones no real client sends.
+type requestLimits struct {
+	urlLength int   // Of the path and query, in bytes.
+	params    int   // Query parameters, counting repeats.
+	body      int64 // In bytes.
+}
+
+// wrap returns h, rejecting requests over the limits. Limits of 0 aren't
+// enforced. Restoring a backup is exempt from the body limit, as backups are
+// as big as the history database.
+func (l requestLimits) wrap(dc domainCollector, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := len(r.URL.RequestURI()); l.urlLength > 0 && n > l.urlLength {
+			dc.rejectLimit(w, reasonURLTooLong, http.StatusRequestURITooLong, "URL is %d bytes, more than --web.max-url-length of %d", n, l.urlLength)
+			return
+		}
+		if l.params > 0 {
+			// Count separators rather than parsing, which is the expensive
+			// part of too many parameters.
+			if n := strings.Count(r.URL.RawQuery, "&") + 1; r.URL.RawQuery != "" && n > l.params {
+				dc.rejectLimit(w, reasonTooManyParams, http.StatusRequestURITooLong, "%d query parameters, more than --web.max-params of %d", n, l.params)
+				return
+			}
+		}
+		if l.body > 0 && r.URL.Path != "/admin/restore" {
+			if r.ContentLength > l.body {
+				dc.rejectLimit(w, reasonBodyTooLarge, http.StatusRequestEntityTooLarge, "body is %d bytes, more than --web.max-request-bytes of %d", r.ContentLength, l.body)
+				return
+			}
+			// Bodies without a length are cut off at the limit, failing
+			// whatever's reading them.
+			r.Body = http.MaxBytesReader(w, r.Body, l.body)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rejectLimit responds to a request over a limit with code, and a JSON body
+// saying which limit, counting it in domain_bad_request_total.
+func (dc domainCollector) rejectLimit(w http.ResponseWriter, reason string, code int, format string, a ...any) {
+	dc.badRequest(w, &paramError{Message: fmt.Sprintf(format, a...), Reason: reason, code: code})
+}
+
+// bodyErrorStatus returns the status code for an error reading a request
+// body: 413 if it was cut off by --web.max-request-bytes, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
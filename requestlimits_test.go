@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestLimits(t *testing.T) {
+	dc := domainCollector{badRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "bad_requests_total"}, []string{"reason"})}
+	h := requestLimits{urlLength: 40, params: 3, body: 10}.wrap(dc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+		}
+	}))
+	for _, tc := range []struct {
+		name, method, url, body string
+		// chunked sends the body without a Content-Length.
+		chunked    bool
+		wantCode   int
+		wantReason string
+	}{
+		{name: "ok", method: "GET", url: "/listings?module=a", wantCode: 200},
+		{name: "long URL", method: "GET", url: "/listings?suburb=" + strings.Repeat("a", 30), wantCode: 414, wantReason: reasonURLTooLong},
+		{name: "three params", method: "GET", url: "/?a=1&b=2&c=3", wantCode: 200},
+		{name: "four params", method: "GET", url: "/?a=1&b=2&c=3&d=4", wantCode: 414, wantReason: reasonTooManyParams},
+		{name: "small body", method: "POST", url: "/api/v1/searches", body: "{}", wantCode: 200},
+		{name: "big body", method: "POST", url: "/api/v1/searches", body: strings.Repeat("a", 11), wantCode: 413, wantReason: reasonBodyTooLarge},
+		{name: "big chunked body", method: "POST", url: "/api/v1/searches", body: strings.Repeat("a", 11), chunked: true, wantCode: 413},
+		{name: "restore", method: "POST", url: "/admin/restore", body: strings.Repeat("a", 11), wantCode: 200},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if tc.chunked {
+				r.ContentLength = -1
+			}
+			var before float64
+			if tc.wantReason != "" {
+				before = testutil.ToFloat64(dc.badRequests.WithLabelValues(tc.wantReason))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Errorf("got %d, want %d: %s", w.Code, tc.wantCode, w.Body)
+			}
+			if tc.wantReason != "" {
+				if got := testutil.ToFloat64(dc.badRequests.WithLabelValues(tc.wantReason)) - before; got != 1 {
+					t.Errorf("counted %v %s bad requests, want 1", got, tc.wantReason)
+				}
+				if !strings.Contains(w.Body.String(), tc.wantReason) {
+					t.Errorf("body %q doesn't say %s", w.Body, tc.wantReason)
+				}
+			}
+		})
+	}
+
+	// No limits.
+	h = requestLimits{}.wrap(dc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/?"+strings.Repeat("a=1&", 100), strings.NewReader(strings.Repeat("a", 1000))))
+	if w.Code != 200 {
+		t.Errorf("got %d without limits, want 200", w.Code)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+)
+
+// sdTargetGroup is one target group in a Prometheus HTTP service discovery
+// response, see
+// https://prometheus.io/docs/prometheus/latest/http_sd/.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves a target group for each search module, all scraping
+// /listings on this exporter at the address Prometheus reached it on.
+func (dc domainCollector) sdHandler(w http.ResponseWriter, r *http.Request) {
+	groups := []sdTargetGroup{}
+	for _, name := range dc.searches.names() {
+		groups = append(groups, sdTargetGroup{
+			Targets: []string{r.Host},
+			Labels: map[string]string{
+				"__metrics_path__": "/listings",
+				"__param_module":   name,
+				"module":           name,
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/mhansen/domain_exporter/pkg/collector"
+)
+
+// searchSet holds the named searches ("modules") loaded from a directory of
+// JSON files, each a Domain /v1/listings/residential/_search request body.
+// A search is named after its file, so searches/rent_3br.json is used for
+// ?module=rent_3br.
+type searchSet struct {
+	dir string
+
+	mu       sync.RWMutex
+	searches map[string]domain.ResidentialSearchRequest
+	// added are the searches added by add, rather than read from dir.
+	added map[string]bool
+	// writeMu serializes changes to the files in dir, see searchAPI.
+	writeMu sync.Mutex
+}
+
+// loadSearches reads all the searches in dir. A missing directory is the same
+// as an empty one.
+func loadSearches(dir string) (*searchSet, error) {
+	s := &searchSet{dir: dir, searches: map[string]domain.ResidentialSearchRequest{}, added: map[string]bool{}}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		rsr, err := readSearch(f)
+		if err != nil {
+			return nil, err
+		}
+		s.searches[strings.TrimSuffix(filepath.Base(f), ".json")] = rsr
+	}
+	return s, nil
+}
+
+// readSearch reads a search from a file.
+func readSearch(path string) (domain.ResidentialSearchRequest, error) {
+	var rsr domain.ResidentialSearchRequest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rsr, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&rsr); err != nil {
+		return rsr, fmt.Errorf("couldn't parse %s: %v", path, err)
+	}
+	return rsr, nil
+}
+
+// get returns the named search.
+func (s *searchSet) get(name string) (domain.ResidentialSearchRequest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rsr, ok := s.searches[name]
+	return rsr, ok
+}
+
+// add adds a search, unless there's already one with that name. It reports
+// whether it was added.
+func (s *searchSet) add(name string, rsr domain.ResidentialSearchRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.searches[name]; ok {
+		return false
+	}
+	s.searches[name] = rsr
+	s.added[name] = true
+	return true
+}
+
+// reload reads the searches in the directory again, keeping those added
+// since unless a file now has the same name. Nothing changes if any file is
+// bad.
+func (s *searchSet) reload() error {
+	fresh, err := loadSearches(s.dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.added {
+		if _, ok := fresh.searches[name]; ok {
+			delete(s.added, name)
+		} else {
+			fresh.searches[name] = s.searches[name]
+		}
+	}
+	s.searches = fresh.searches
+	return nil
+}
+
+// names returns the names of all searches, sorted.
+func (s *searchSet) names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.searches))
+	for n := range s.searches {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// all returns a copy of all the searches, by name.
+func (s *searchSet) all() map[string]domain.ResidentialSearchRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]domain.ResidentialSearchRequest, len(s.searches))
+	for n, rsr := range s.searches {
+		all[n] = rsr
+	}
+	return all
+}
+
+// searchParams are the parameters searchFromQuery understands.
+var searchParams = []string{"module", "state", "suburb", "postCode", "includeSurroundingSuburbs", "listingType", "minBedrooms", "maxBedrooms"}
+
+// searchFromQuery returns the search asked for by a request's URL parameters:
+// either a named search with ?module=<name>, or an ad-hoc search in a
+// location given by ?state=, ?suburb= and ?postCode=. Ad-hoc searches are for
+// rentals unless ?listingType= says otherwise, and can be narrowed with
+// ?minBedrooms= and ?maxBedrooms=, or widened with
+// ?includeSurroundingSuburbs=true, unless they're turned off by
+// --web.read-only, and limited to --web.allowed-locations and
+// --web.max-search-cost. Any other parameters must be in extra, so
+// typos are rejected rather than quietly searching for something else. Errors
+// are paramErrors.
+func (dc domainCollector) searchFromQuery(q url.Values, extra ...string) (string, domain.ResidentialSearchRequest, error) {
+	var rsr domain.ResidentialSearchRequest
+	if err := checkParams(q, append(slices.Clip(searchParams), extra...)...); err != nil {
+		return "", rsr, err
+	}
+	if module := q.Get("module"); module != "" {
+		for _, p := range searchParams[1:] {
+			if q.Get(p) != "" {
+				return "", rsr, badParam(reasonConflictingParam, p, q.Get(p), "%s can't be used with module", p)
+			}
+		}
+		rsr, ok := dc.searches.get(module)
+		if !ok {
+			return "", rsr, badParam(reasonUnknownModule, "module", module, "unknown module %q", module)
+		}
+		return module, rsr, nil
+	}
+	if dc.readOnly {
+		pe := badParam(reasonAdHocSearch, "module", "", "only configured searches can be run, pass ?module=")
+		pe.code = http.StatusForbidden
+		return "", rsr, pe
+	}
+	state := strings.ToUpper(strings.TrimSpace(q.Get("state")))
+	if state != "" && !slices.Contains(states, state) {
+		return "", rsr, badParam(reasonInvalidState, "state", q.Get("state"), "unknown state %q, want one of %s", q.Get("state"), strings.Join(states, ", "))
+	}
+	postCode := strings.TrimSpace(q.Get("postCode"))
+	if postCode != "" && !postcodeRE.MatchString(postCode) {
+		return "", rsr, badParam(reasonInvalidPostcode, "postCode", q.Get("postCode"), "bad postCode %q, want 4 digits", q.Get("postCode"))
+	}
+	suburb := strings.Join(strings.Fields(q.Get("suburb")), " ")
+	if state == "" && suburb == "" && postCode == "" {
+		return "", rsr, badParam(reasonMissingParam, "", "", "pass ?module=, or a location with ?state=, ?suburb= or ?postCode=")
+	}
+	rsr = domain.ResidentialSearchRequest{
+		ListingType: "Rent",
+		Locations: []domain.LocationFilter{
+			{
+				State:                     state,
+				Area:                      "",
+				Region:                    "",
+				Suburb:                    suburb,
+				PostCode:                  postCode,
+				IncludeSurroundingSuburbs: false,
+			},
+		},
+	}
+	if t := q.Get("listingType"); t != "" {
+		if !slices.Contains(listingTypes, t) {
+			return "", rsr, badParam(reasonInvalidValue, "listingType", t, "unknown listingType %q, want one of %s", t, strings.Join(listingTypes, ", "))
+		}
+		rsr.ListingType = t
+	}
+	for _, param := range []string{"minBedrooms", "maxBedrooms"} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return "", rsr, badParam(reasonInvalidValue, param, v, "bad %s %q, want a number of bedrooms", param, v)
+		}
+		f32 := float32(f)
+		if param == "minBedrooms" {
+			rsr.MinBedrooms = &f32
+		} else {
+			rsr.MaxBedrooms = &f32
+		}
+	}
+	if rsr.MinBedrooms != nil && rsr.MaxBedrooms != nil && *rsr.MinBedrooms > *rsr.MaxBedrooms {
+		return "", rsr, badParam(reasonConflictingParam, "maxBedrooms", q.Get("maxBedrooms"), "maxBedrooms is less than minBedrooms")
+	}
+	if v := q.Get("includeSurroundingSuburbs"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", rsr, badParam(reasonInvalidValue, "includeSurroundingSuburbs", v, "bad includeSurroundingSuburbs %q, want true or false", v)
+		}
+		rsr.Locations[0].IncludeSurroundingSuburbs = b
+	}
+	// Checked after includeSurroundingSuburbs, which can widen a search
+	// beyond what's allowed.
+	if loc := rsr.Locations[0]; !dc.locations.allows(loc) {
+		pe := badParam(reasonLocation, "", "", "searches in %s aren't allowed by --web.allowed-locations", locationName(loc))
+		if narrow := loc; narrow.IncludeSurroundingSuburbs {
+			narrow.IncludeSurroundingSuburbs = false
+			if dc.locations.allows(narrow) {
+				pe = badParam(reasonLocation, "includeSurroundingSuburbs", "true", "searches of the suburbs surrounding %s aren't allowed by --web.allowed-locations, only of the whole state", locationName(loc))
+			}
+		}
+		pe.code = http.StatusForbidden
+		return "", rsr, pe
+	}
+	if err := dc.checkSearchCost(&rsr); err != nil {
+		return "", rsr, err
+	}
+	return "", rsr, nil
+}
+
+// listingTypes are the listing types ad-hoc searches can ask for.
+var listingTypes = []string{"Rent", "Sale", "Share", "Sold"}
+
+// states are the states and territories ad-hoc searches can be in.
+var states = []string{"NSW", "VIC", "QLD", "WA", "SA", "TAS", "ACT", "NT"}
+
+// postcodeRE matches Australian postcodes.
+var postcodeRE = regexp.MustCompile(`^[0-9]{4}$`)
+
+// search runs a search against the Domain API, logging how it went.
+func (dc domainCollector) search(ctx context.Context, module string, rsr domain.ResidentialSearchRequest) ([]domain.SearchResult, error) {
+	ctx = dc.tenants.forModule(ctx, module)
+	logger := searchLogger(ctx, module, rsr)
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "search")
+	defer span.End()
+	listings, err := dc.client(ctx).SearchResidential(rsr)
+	if err != nil {
+		err = redactError(err)
+		logger.Error("error searching domain", "duration", time.Since(start), "status", "error", "err", err)
+		return nil, err
+	}
+	logger.Info("searched domain", "duration", time.Since(start), "status", "ok", "listings", len(listings))
+	now := time.Now()
+	key := searchKey(module, rsr)
+	events := dc.seen.observe(key, listings, now)
+	dc.notify.send(events)
+	dc.notify.send(dc.alerts.evaluate(key, listings, events, now))
+	dc.hub.publish(events)
+	dc.digest.add(events)
+	if dc.history != nil || dc.parquet != nil || dc.sheets != nil || dc.ha != nil {
+		summaries := make([]listingSummary, len(listings))
+		for i, l := range listings {
+			summaries[i] = summarize(l)
+		}
+		if err := dc.history.record(ctx, key, now, summaries); err != nil {
+			logger.Error("error recording history", "err", secrets.redact(err.Error()))
+		}
+		dc.parquet.write(key, now, summaries)
+		// Shared with other replicas, unlike history and Parquet files.
+		if dc.leader.isLeading() {
+			dc.sheets.write(key, now, summaries, events)
+			dc.ha.publish(key, summaries)
+		}
+	}
+	return listings, nil
+}
+
+// searchLogger returns a logger with fields describing a search.
+func searchLogger(ctx context.Context, module string, rsr domain.ResidentialSearchRequest) *slog.Logger {
+	logger := slog.With("request_id", requestID(ctx))
+	if t := tenant(ctx); t != "" {
+		logger = logger.With("tenant", t)
+	}
+	if module != "" {
+		return logger.With("module", module)
+	}
+	if len(rsr.Locations) > 0 {
+		logger = logger.With(
+			"state", rsr.Locations[0].State,
+			"suburb", rsr.Locations[0].Suburb,
+			"postcode", rsr.Locations[0].PostCode,
+		)
+	}
+	return logger
+}
+
+// listingSummary is the interesting parts of a listing, for the non-metrics
+// endpoints.
+type listingSummary struct {
+	ID           int32   `json:"id"`
+	Headline     string  `json:"headline"`
+	Address      string  `json:"address"`
+	Suburb       string  `json:"suburb"`
+	State        string  `json:"state"`
+	Postcode     string  `json:"postcode"`
+	PropertyType string  `json:"propertyType"`
+	Bedrooms     float32 `json:"bedrooms"`
+	Bathrooms    float32 `json:"bathrooms"`
+	Carspaces    int32   `json:"carspaces"`
+	DisplayPrice string  `json:"displayPrice"`
+	Price        float64 `json:"price,omitempty"`
+	DateListed   string  `json:"dateListed"`
+	Latitude     float32 `json:"latitude"`
+	Longitude    float32 `json:"longitude"`
+	URL          string  `json:"url"`
+}
+
+func summarize(r domain.SearchResult) listingSummary {
+	l, p := r.Listing, r.Listing.PropertyDetails
+	return listingSummary{
+		ID:           l.ID,
+		Headline:     l.Headline,
+		Address:      p.DisplayableAddress,
+		Suburb:       p.Suburb,
+		State:        p.State,
+		Postcode:     p.Postcode,
+		PropertyType: p.PropertyType,
+		Bedrooms:     p.Bedrooms,
+		Bathrooms:    p.Bathrooms,
+		Carspaces:    p.CarSpaces,
+		DisplayPrice: l.PriceDetails.DisplayPrice,
+		Price:        collector.ParsePrice(l.PriceDetails),
+		DateListed:   l.DateListed,
+		Latitude:     p.Latitude,
+		Longitude:    p.Longitude,
+		URL:          "https://www.domain.com.au/" + l.ListingSlug,
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mhansen/domain"
+)
+
+// disabledSuffix is added to the files of disabled searches, so loadSearches
+// skips them but they can be turned back on.
+const disabledSuffix = ".disabled"
+
+var searchNameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// searchAPI serves /api/v1/searches, for companion UIs and bots to manage the
+// named searches at runtime. Changes are written to --searches_dir, so they
+// outlast restarts and can be seen in the files.
+type searchAPI struct {
+	dc   domainCollector
+	auth *httpAuth
+}
+
+// managedSearch is a search as the API lists it.
+type managedSearch struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Discovered searches were added by --discover.modules, rather than read
+	// from a file.
+	Discovered bool                            `json:"discovered,omitempty"`
+	Search     domain.ResidentialSearchRequest `json:"search"`
+}
+
+// register adds the endpoints to mux:
+//
+//	GET    /api/v1/searches               lists the searches
+//	GET    /api/v1/searches/<name>        returns one
+//	PUT    /api/v1/searches/<name>        adds or replaces one
+//	DELETE /api/v1/searches/<name>        deletes one
+//	POST   /api/v1/searches/<name>/enable or /disable
+func (a searchAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/searches", a.auth.wrap(a.list))
+	mux.HandleFunc("/api/v1/searches/", a.auth.wrap(a.handle))
+}
+
+func (a searchAPI) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	searches, err := a.dc.searches.managed()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, searches)
+}
+
+func (a searchAPI) handle(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/searches/"), "/")
+	if !searchNameRE.MatchString(name) {
+		a.dc.badRequest(w, badParam(reasonInvalidValue, "name", name, "bad search name %q, want letters, digits, _ or -", name))
+		return
+	}
+	allow := "GET, PUT, DELETE"
+	if action != "" {
+		allow = "POST"
+	}
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		a.get(w, name)
+		return
+	case action == "" && r.Method == http.MethodPut:
+		a.put(w, r, name)
+		return
+	case action == "" && r.Method == http.MethodDelete:
+		err = a.dc.searches.remove(name)
+	case (action == "enable" || action == "disable") && r.Method == http.MethodPost:
+		err = a.dc.searches.setEnabled(name, action == "enable")
+	case action != "enable" && action != "disable":
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("unknown action %q, want enable or disable", action))
+		return
+	default:
+		w.Header().Set("Allow", allow)
+		writeJSONError(w, http.StatusMethodNotAllowed, "use "+allow)
+		return
+	}
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	slog.Info("Changed search through the API", "search", name, "method", r.Method, "action", action)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a searchAPI) get(w http.ResponseWriter, name string) {
+	searches, err := a.dc.searches.managed()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, s := range searches {
+		if s.Name == name {
+			writeJSON(w, http.StatusOK, s)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no search %q", name))
+}
+
+func (a searchAPI) put(w http.ResponseWriter, r *http.Request, name string) {
+	var rsr domain.ResidentialSearchRequest
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&rsr); err != nil {
+		pe := badParam(reasonInvalidValue, "", "", "couldn't parse search: %v", err)
+		pe.code = bodyErrorStatus(err)
+		a.dc.badRequest(w, pe)
+		return
+	}
+	if err := checkManagedSearch(rsr); err != nil {
+		a.dc.badRequest(w, err)
+		return
+	}
+	created, err := a.dc.searches.put(name, rsr)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	slog.Info("Changed search through the API", "search", name, "method", r.Method, "created", created)
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, managedSearch{Name: name, Enabled: true, Search: rsr})
+}
+
+// fail responds to an error changing a search.
+func (a searchAPI) fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	slog.Error("error changing search", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// checkManagedSearch checks a search added through the API as far as we can
+// without running it.
+func checkManagedSearch(rsr domain.ResidentialSearchRequest) error {
+	if len(rsr.Locations) == 0 {
+		return badParam(reasonMissingParam, "locations", "", "a search needs at least one location")
+	}
+	for _, l := range rsr.Locations {
+		if l.State != "" && !slices.Contains(states, l.State) {
+			return badParam(reasonInvalidState, "state", l.State, "unknown state %q, want one of %s", l.State, strings.Join(states, ", "))
+		}
+		if l.PostCode != "" && !postcodeRE.MatchString(l.PostCode) {
+			return badParam(reasonInvalidPostcode, "postCode", l.PostCode, "bad postCode %q, want 4 digits", l.PostCode)
+		}
+	}
+	return nil
+}
+
+// managed returns every search, including disabled ones, sorted by name.
+func (s *searchSet) managed() ([]managedSearch, error) {
+	disabled, err := filepath.Glob(filepath.Join(s.dir, "*.json"+disabledSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var searches []managedSearch
+	s.mu.RLock()
+	for name, rsr := range s.searches {
+		searches = append(searches, managedSearch{Name: name, Enabled: true, Discovered: s.added[name], Search: rsr})
+	}
+	s.mu.RUnlock()
+	for _, f := range disabled {
+		rsr, err := readSearch(f)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, managedSearch{Name: strings.TrimSuffix(filepath.Base(f), ".json"+disabledSuffix), Search: rsr})
+	}
+	slices.SortFunc(searches, func(a, b managedSearch) int { return strings.Compare(a.Name, b.Name) })
+	return searches, nil
+}
+
+// put writes a search to its file, replacing any disabled one, and reloads
+// the searches. It reports whether the search is new.
+func (s *searchSet) put(name string, rsr domain.ResidentialSearchRequest) (created bool, err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, exists := s.get(name)
+	path := filepath.Join(s.dir, name+".json")
+	if _, err := os.Stat(path + disabledSuffix); err == nil {
+		exists = true
+	}
+	b, err := json.MarshalIndent(rsr, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return false, err
+	}
+	// Written to a temporary file and renamed, so a reload never reads half
+	// a search.
+	tmp, err := os.CreateTemp(s.dir, "."+name+"-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	os.Remove(path + disabledSuffix)
+	// Written to a file, so not just discovered any more.
+	s.mu.Lock()
+	delete(s.added, name)
+	s.mu.Unlock()
+	return !exists, s.reload()
+}
+
+// remove deletes a search, whether it's enabled or not, and reloads the
+// searches.
+func (s *searchSet) remove(name string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	path := filepath.Join(s.dir, name+".json")
+	s.mu.Lock()
+	discovered := s.added[name]
+	if discovered {
+		delete(s.added, name)
+		delete(s.searches, name)
+	}
+	s.mu.Unlock()
+	removed := discovered
+	for _, p := range []string{path, path + disabledSuffix} {
+		err := os.Remove(p)
+		if err == nil {
+			removed = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if !removed {
+		return fmt.Errorf("no search %q: %w", name, fs.ErrNotExist)
+	}
+	return s.reload()
+}
+
+// setEnabled turns a search in --searches_dir on or off, by renaming its
+// file, and reloads the searches.
+func (s *searchSet) setEnabled(name string, enabled bool) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	on := filepath.Join(s.dir, name+".json")
+	from, to := on+disabledSuffix, on
+	if !enabled {
+		from, to = to, from
+	}
+	if _, err := os.Stat(to); err == nil {
+		// Already how it should be.
+		return nil
+	}
+	if err := os.Rename(from, to); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no search %q in --searches_dir: %w", name, fs.ErrNotExist)
+		}
+		return err
+	}
+	return s.reload()
+}
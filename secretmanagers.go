@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// resolveSecret fetches a secret referred to by a URI from a secret manager.
+// Values that aren't secret manager URIs are returned unchanged. Supported:
+//
+//	gcpsm://<project>/<secret>[/<version>]  Google Cloud Secret Manager
+//	vault://<path>#<field>                  HashiCorp Vault KV v1 or v2
+//	awssm://<secret id>                     AWS Secrets Manager
+func resolveSecret(ctx context.Context, s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s, nil
+	}
+	switch u.Scheme {
+	case "gcpsm":
+		return gcpSecret(ctx, u.Host+u.Path)
+	case "vault":
+		return vaultSecret(ctx, u.Host+u.Path, u.Fragment)
+	case "awssm":
+		return awsSecret(ctx, strings.TrimPrefix(s, "awssm://"))
+	}
+	return s, nil
+}
+
+// gcpSecret reads a secret from Google Cloud Secret Manager using
+// Application Default Credentials.
+func gcpSecret(ctx context.Context, name string) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) == 2 {
+		parts = append(parts, "latest")
+	}
+	if len(parts) != 3 {
+		return "", fmt.Errorf("want gcpsm://<project>/<secret>[/<version>], got gcpsm://%s", name)
+	}
+	c, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/%s:access", parts[0], parts[1], parts[2])
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getJSON(ctx, c, u, nil, &resp); err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// vaultSecret reads a field of a secret from Vault, configured with the
+// usual $VAULT_ADDR and $VAULT_TOKEN environment variables.
+func vaultSecret(ctx context.Context, path, field string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("$VAULT_ADDR must be set to read vault:// secrets")
+	}
+	if field == "" {
+		return "", fmt.Errorf("want vault://<path>#<field>, got vault://%s", path)
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	h := http.Header{"X-Vault-Token": {os.Getenv("VAULT_TOKEN")}}
+	if err := getJSON(ctx, http.DefaultClient, strings.TrimRight(addr, "/")+"/v1/"+path, h, &resp); err != nil {
+		return "", err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret one level deeper.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no string field %q in vault secret %s", field, path)
+	}
+	return v, nil
+}
+
+func getJSON(ctx context.Context, c *http.Client, u string, h http.Header, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range h {
+		req.Header[k] = vs
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// awsSecret reads a secret from AWS Secrets Manager. Credentials and region
+// come from the standard $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY,
+// $AWS_SESSION_TOKEN and $AWS_REGION environment variables.
+func awsSecret(ctx context.Context, id string) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || keyID == "" || secret == "" {
+		return "", fmt.Errorf("$AWS_REGION, $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY must be set to read awssm:// secrets")
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	host := "secretsmanager." + region + ".amazonaws.com"
+	req, err := http.NewRequest("POST", "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSv4(req, body, host, region, "secretsmanager", keyID, secret, time.Now().UTC())
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("GetSecretValue %s: %s: %s", id, resp.Status, b)
+	}
+	var v struct {
+		SecretString string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	return v.SecretString, nil
+}
+
+// signAWSv4 adds an AWS Signature Version 4 Authorization header to req.
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func signAWSv4(req *http.Request, body []byte, host, region, service, keyID, secret string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, strings.TrimSpace(req.Header.Get(k)))
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secret)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		keyID, scope, signedHeaders, hmacSHA256(key, stringToSign)))
+	// Go sends Host from req.Host, not the header map.
+	req.Header.Del("Host")
+}
+
+// awsCanonicalQuery returns q sorted by name then value, and escaped like
+// AWS wants, with spaces as %20 rather than +.
+func awsCanonicalQuery(q url.Values) string {
+	escaped := make(map[string][]string, len(q))
+	var names []string
+	for k, vs := range q {
+		k = awsEscape(k)
+		names = append(names, k)
+		for _, v := range vs {
+			escaped[k] = append(escaped[k], awsEscape(v))
+		}
+		sort.Strings(escaped[k])
+	}
+	sort.Strings(names)
+	var params []string
+	for _, k := range names {
+		for _, v := range escaped[k] {
+			params = append(params, k+"="+v)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secrets holds every API key and client secret the exporter knows about, so
+// they can be scrubbed from anything we log or send back to clients.
+var secrets redactor
+
+type redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// add adds secrets, along with the ways they're escaped in URLs, form
+// bodies, JSON and quoted log values, so they're caught however they're
+// written.
+func (r *redactor) add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+		j, _ := json.Marshal(s)
+		q := strconv.Quote(s)
+		for _, v := range []string{s, url.QueryEscape(s), url.PathEscape(s), string(j[1 : len(j)-1]), q[1 : len(q)-1]} {
+			if !slices.Contains(r.secrets, v) {
+				r.secrets = append(r.secrets, v)
+			}
+		}
+	}
+}
+
+// redact replaces any known secrets in s.
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, "<redacted>", -1)
+	}
+	return s
+}
+
+// redactWriter scrubs secrets from everything written through it.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, secrets.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactedError scrubs secrets from an error's message, keeping the error it
+// wraps for errors.Is and errors.As.
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string { return secrets.redact(e.err.Error()) }
+
+func (e redactedError) Unwrap() error { return e.err }
+
+// redactError returns err with secrets scrubbed from its message, or nil if
+// err is nil. Errors from the Domain API and its HTTP client go through it
+// before they're returned, so they're safe to log or show to clients.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(redactedError); ok {
+		return err
+	}
+	return redactedError{err}
+}
+
+// redactTransport scrubs secrets from the errors of requests made through
+// it, which can include OAuth token endpoint responses, or URLs with secrets
+// in them.
+type redactTransport struct {
+	base http.RoundTripper
+}
+
+func (t redactTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	return resp, redactError(err)
+}
+
+// redactErrors scrubs secrets from the bodies of error responses, which
+// often include errors from the Domain API or from sinks like webhooks.
+func redactErrors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&redactingResponseWriter{ResponseWriter: w}, r)
+	})
+}
+
+// redactingResponseWriter redacts what's written after an error status.
+type redactingResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *redactingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *redactingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status < 400 {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := io.WriteString(w.ResponseWriter, secrets.redact(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *redactingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades through.
+func (w *redactingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// cmdlineHandler serves /debug/pprof/cmdline like pprof.Cmdline, with secrets
+// passed as flags redacted.
+func cmdlineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, secrets.redact(strings.Join(os.Args, "\x00")))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxRecentListings is how many newly seen listings are remembered per search.
+const maxRecentListings = 100
+
+// maxRecentEvents is how many events are remembered, across all searches.
+const maxRecentEvents = 1000
+
+// maxAdHocSearches is how many ad-hoc searches, rather than modules, are
+// remembered. Anyone who can reach /listings can make up new ones, so the
+// least recently run are forgotten past it.
+const maxAdHocSearches = 1000
+
+// Types of listingEvent.
+const (
+	eventNew       = "new"
+	eventPriceDrop = "price_drop"
+	eventPriceRise = "price_rise"
+	eventRemoved   = "removed"
+	// eventAlert is an alert rule firing or resolving, rather than a change
+	// to a listing.
+	eventAlert = "alert"
+)
+
+// listingEvent is a change to the listings found by a search.
+type listingEvent struct {
+	Type          string         `json:"type"`
+	Search        string         `json:"search"`
+	Time          time.Time      `json:"time"`
+	Listing       listingSummary `json:"listing"`
+	Price         float64        `json:"price,omitempty"`
+	PreviousPrice float64        `json:"previousPrice,omitempty"`
+	// Alert describes an eventAlert.
+	Alert string `json:"alert,omitempty"`
+}
+
+// seenListing is a listing and when it was first seen by a search.
+type seenListing struct {
+	listingSummary
+	FirstSeen time.Time
+}
+
+// seenTracker remembers which listings each search has found, so that new,
+// removed and repriced listings can be picked out. It's kept in memory, so
+// history starts again after a restart.
+type seenTracker struct {
+	mu       sync.Mutex
+	searches map[string]*seenSearch
+	events   []listingEvent // Oldest first.
+	// keepPayloads keeps each search's full listings for payloads.
+	keepPayloads bool
+}
+
+type seenSearch struct {
+	listings map[int32]seenListing
+	recent   []seenListing // Newest first.
+	// created is when the search was first run, and counts how many of each
+	// type of event it's seen since.
+	created time.Time
+	counts  map[string]float64
+	// updated is when the search was last run.
+	updated time.Time
+	// payloads are the listings as decoded from Domain's responses the last
+	// time the search ran, kept only with --debug.raw-payloads.
+	payloads []domain.SearchResult
+}
+
+func newSeenTracker() *seenTracker {
+	return &seenTracker{searches: map[string]*seenSearch{}}
+}
+
+// searchKey identifies a search for the seenTracker: the module name, or the
+// location of an ad-hoc search.
+func searchKey(module string, rsr domain.ResidentialSearchRequest) string {
+	if module != "" {
+		return "module:" + module
+	}
+	var parts []string
+	for _, l := range rsr.Locations {
+		parts = append(parts, strings.ToLower(strings.Join([]string{l.State, l.Suburb, l.PostCode}, "/")))
+	}
+	key := "location:" + strings.Join(parts, ",")
+	// Rentals of any size keep the plain location, as they did before other
+	// searches could be asked for.
+	if rsr.ListingType != "" && rsr.ListingType != "Rent" {
+		key += ";" + strings.ToLower(rsr.ListingType)
+	}
+	if rsr.MinBedrooms != nil {
+		key += fmt.Sprintf(";minbeds=%v", *rsr.MinBedrooms)
+	}
+	if rsr.MaxBedrooms != nil {
+		key += fmt.Sprintf(";maxbeds=%v", *rsr.MaxBedrooms)
+	}
+	if len(rsr.Locations) > 0 && rsr.Locations[0].IncludeSurroundingSuburbs {
+		key += ";surrounding"
+	}
+	return key
+}
+
+// observe records the results of a search, and returns how they've changed
+// since the search was last run. The first time a search is run everything
+// is new, so no events are returned.
+func (t *seenTracker) observe(key string, listings []domain.SearchResult, now time.Time) []listingEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.searches[key]
+	first := !ok
+	if first {
+		if !strings.HasPrefix(key, "module:") {
+			t.evictAdHoc()
+		}
+		s = &seenSearch{listings: map[int32]seenListing{}, created: now, counts: map[string]float64{}}
+		t.searches[key] = s
+	}
+	s.updated = now
+	if t.keepPayloads {
+		s.payloads = listings
+	}
+	var events []listingEvent
+	var added []seenListing
+	found := map[int32]bool{}
+	for _, l := range listings {
+		summary := summarize(l)
+		found[summary.ID] = true
+		old, ok := s.listings[summary.ID]
+		if !ok {
+			sl := seenListing{summary, now}
+			s.listings[summary.ID] = sl
+			added = append(added, sl)
+			events = append(events, listingEvent{Type: eventNew, Search: key, Time: now, Listing: summary, Price: summary.Price})
+			continue
+		}
+		if summary.Price != 0 && old.Price != 0 && summary.Price != old.Price {
+			typ := eventPriceDrop
+			if summary.Price > old.Price {
+				typ = eventPriceRise
+			}
+			events = append(events, listingEvent{Type: typ, Search: key, Time: now, Listing: summary, Price: summary.Price, PreviousPrice: old.Price})
+		}
+		s.listings[summary.ID] = seenListing{summary, old.FirstSeen}
+	}
+	for id, l := range s.listings {
+		if !found[id] {
+			delete(s.listings, id)
+			events = append(events, listingEvent{Type: eventRemoved, Search: key, Time: now, Listing: l.listingSummary, Price: l.Price})
+		}
+	}
+	if first {
+		// Everything is new the first time, so nothing is.
+		return nil
+	}
+	s.recent = append(added, s.recent...)
+	if len(s.recent) > maxRecentListings {
+		s.recent = s.recent[:maxRecentListings]
+	}
+	for _, e := range events {
+		s.counts[e.Type]++
+	}
+	t.events = append(t.events, events...)
+	if len(t.events) > maxRecentEvents {
+		t.events = append([]listingEvent(nil), t.events[len(t.events)-maxRecentEvents:]...)
+	}
+	return events
+}
+
+// evictAdHoc forgets the least recently run ad-hoc search if there are
+// maxAdHocSearches of them. t.mu must be held.
+func (t *seenTracker) evictAdHoc() {
+	n := 0
+	var oldest string
+	for k, s := range t.searches {
+		if strings.HasPrefix(k, "module:") {
+			continue
+		}
+		n++
+		if oldest == "" || s.updated.Before(t.searches[oldest].updated) {
+			oldest = k
+		}
+	}
+	if n >= maxAdHocSearches {
+		delete(t.searches, oldest)
+	}
+}
+
+// recentEvents returns the remembered events between from and to, oldest
+// first.
+func (t *seenTracker) recentEvents(from, to time.Time) []listingEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var events []listingEvent
+	for _, e := range t.events {
+		if !e.Time.Before(from) && !e.Time.After(to) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// recent returns the listings most recently first seen by a search, newest
+// first, not counting those found the first time it ran.
+func (t *seenTracker) recent(key string) []seenListing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.searches[key]
+	if !ok {
+		return nil
+	}
+	return append([]seenListing(nil), s.recent...)
+}
+
+// eventTypes are the types of listingEvent, in the order they're exported.
+var eventTypes = []string{eventNew, eventPriceDrop, eventPriceRise, eventRemoved}
+
+var listingEventsDesc = prometheus.NewDesc(
+	"domain_listing_events_total",
+	"Listings found, repriced or removed by a search since it was first run.",
+	[]string{"search", "type"}, nil,
+)
+
+// seenCollector exports the event counts of the seenTracker, with created
+// timestamps of when each search was first run, so rates stay right across
+// restarts.
+type seenCollector struct {
+	t *seenTracker
+	// key is the only search to export, or every module if empty. Ad-hoc
+	// searches are only exported by their own scrapes, so clients can't add
+	// series to /metrics.
+	key string
+}
+
+// Describe implements prometheus.Collector.
+func (c seenCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listingEventsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c seenCollector) Collect(ch chan<- prometheus.Metric) {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+	for key, s := range c.t.searches {
+		if (c.key != "" && key != c.key) || (c.key == "" && !strings.HasPrefix(key, "module:")) {
+			continue
+		}
+		for _, typ := range eventTypes {
+			ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(listingEventsDesc, prometheus.CounterValue, s.counts[typ], s.created, key, typ)
+		}
+	}
+}
+
+// current returns the listings a search found the last time it ran, newest
+// first, and when that was. ok is false if the search hasn't run.
+func (t *seenTracker) current(key string) (listings []seenListing, updated time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.searches[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	for _, l := range s.listings {
+		listings = append(listings, l)
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if !listings[i].FirstSeen.Equal(listings[j].FirstSeen) {
+			return listings[i].FirstSeen.After(listings[j].FirstSeen)
+		}
+		return listings[i].ID > listings[j].ID
+	})
+	return listings, s.updated, true
+}
+
+// lastRun returns when a search last ran. ok is false if it hasn't.
+func (t *seenTracker) lastRun(key string) (updated time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.searches[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return s.updated, true
+}
+
+// payloads returns the listings a search found the last time it ran, as
+// decoded from Domain's responses. ok is false if the search hasn't run, or payloads
+// aren't kept.
+func (t *seenTracker) payloads(key string) (listings []domain.SearchResult, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.searches[key]
+	if !ok || !t.keepPayloads {
+		return nil, false
+	}
+	return s.payloads, true
+}
+
+// keys returns the keys of the searches that have run, sorted.
+func (t *seenTracker) keys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.searches))
+	for k := range t.searches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
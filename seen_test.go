@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mhansen/domain"
+)
+
+func testListing(id, price int32) domain.SearchResult {
+	var r domain.SearchResult
+	r.Listing.ID = id
+	r.Listing.PriceDetails.Price = price
+	return r
+}
+
+func testListings(ids ...int32) []domain.SearchResult {
+	var rs []domain.SearchResult
+	for _, id := range ids {
+		rs = append(rs, testListing(id, 0))
+	}
+	return rs
+}
+
+func TestSeenTrackerRecent(t *testing.T) {
+	tr := newSeenTracker()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		found []int32
+		want  []int32
+	}{
+		// What's there the first time isn't new.
+		{found: []int32{1, 2}, want: nil},
+		{found: []int32{1, 2, 3}, want: []int32{3}},
+		{found: []int32{2, 3, 4}, want: []int32{4, 3}},
+		{found: []int32{}, want: []int32{4, 3}},
+	} {
+		now = now.Add(time.Hour)
+		tr.observe("module:a", testListings(tc.found...), now)
+		var got []int32
+		for _, l := range tr.recent("module:a") {
+			got = append(got, l.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("after finding %v, recent = %v, want %v", tc.found, got, tc.want)
+		}
+	}
+	if got := tr.recent("module:b"); got != nil {
+		t.Errorf("recent of a search that hasn't run = %v, want none", got)
+	}
+}
+
+func TestSeenTrackerObserve(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// before is what the search found the last time it ran, if it has.
+		before []domain.SearchResult
+		after  []domain.SearchResult
+		// want are the types of events, by listing ID.
+		want map[int32]string
+	}{
+		{
+			name:  "first run",
+			after: []domain.SearchResult{testListing(1, 500), testListing(2, 600)},
+			want:  map[int32]string{},
+		},
+		{
+			name:   "unchanged",
+			before: []domain.SearchResult{testListing(1, 500)},
+			after:  []domain.SearchResult{testListing(1, 500)},
+			want:   map[int32]string{},
+		},
+		{
+			name:   "new",
+			before: []domain.SearchResult{testListing(1, 500)},
+			after:  []domain.SearchResult{testListing(1, 500), testListing(2, 600)},
+			want:   map[int32]string{2: eventNew},
+		},
+		{
+			name:   "removed",
+			before: []domain.SearchResult{testListing(1, 500), testListing(2, 600)},
+			after:  []domain.SearchResult{testListing(2, 600)},
+			want:   map[int32]string{1: eventRemoved},
+		},
+		{
+			name:   "price changes",
+			before: []domain.SearchResult{testListing(1, 500), testListing(2, 600)},
+			after:  []domain.SearchResult{testListing(1, 450), testListing(2, 650)},
+			want:   map[int32]string{1: eventPriceDrop, 2: eventPriceRise},
+		},
+		{
+			name:   "price hidden",
+			before: []domain.SearchResult{testListing(1, 500)},
+			after:  []domain.SearchResult{testListing(1, 0)},
+			want:   map[int32]string{},
+		},
+		{
+			name:   "empty results",
+			before: []domain.SearchResult{},
+			after:  []domain.SearchResult{testListing(1, 500)},
+			want:   map[int32]string{1: eventNew},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newSeenTracker()
+			now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+			if tc.before != nil {
+				tr.observe("module:a", tc.before, now)
+			}
+			events := tr.observe("module:a", tc.after, now.Add(time.Hour))
+			got := map[int32]string{}
+			for _, e := range events {
+				if e.Search != "module:a" || !e.Time.Equal(now.Add(time.Hour)) {
+					t.Errorf("event %+v isn't for the search at the time it ran", e)
+				}
+				got[e.Listing.ID] = e.Type
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("events = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSeenTrackerObservePrices(t *testing.T) {
+	tr := newSeenTracker()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tr.observe("module:a", []domain.SearchResult{testListing(1, 500)}, now)
+	events := tr.observe("module:a", []domain.SearchResult{testListing(1, 450)}, now.Add(time.Hour))
+	if len(events) != 1 || events[0].Price != 450 || events[0].PreviousPrice != 500 {
+		t.Errorf("events = %+v, want a drop from 500 to 450", events)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mhansen/domain_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2/google"
+)
+
+// sheetsColumns are the columns of the rows sheetsSink appends.
+var sheetsColumns = []string{"time", "search", "suburb", "listings", "median_price", "new_listings"}
+
+// sheetsSink appends a row per suburb to a Google Sheet each time a search
+// runs, for house hunters who keep their shortlist in a spreadsheet anyway.
+type sheetsSink struct {
+	spreadsheetID string
+	sheet         string
+	writes        *prometheus.CounterVec
+}
+
+func newSheetsSink(spreadsheetID, sheet string) *sheetsSink {
+	s := &sheetsSink{
+		spreadsheetID: spreadsheetID,
+		sheet:         sheet,
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "domain_sheets_writes_total",
+			Help: "Number of search summaries appended to Google Sheets, by whether they were appended.",
+		}, []string{"result"}),
+	}
+	s.writes.WithLabelValues("success")
+	s.writes.WithLabelValues("failure")
+	return s
+}
+
+// sheetsRows summarises listings by suburb, with how many of them events
+// says are new, in the order of sheetsColumns.
+func sheetsRows(search string, observed time.Time, listings []listingSummary, events []listingEvent) [][]interface{} {
+	type suburb struct {
+		count, new int
+		prices     []float64
+	}
+	suburbs := map[string]*suburb{}
+	for _, l := range listings {
+		s, ok := suburbs[l.Suburb]
+		if !ok {
+			s = &suburb{}
+			suburbs[l.Suburb] = s
+		}
+		s.count++
+		if l.Price != 0 {
+			s.prices = append(s.prices, l.Price)
+		}
+	}
+	for _, e := range events {
+		if s, ok := suburbs[e.Listing.Suburb]; ok && e.Type == eventNew {
+			s.new++
+		}
+	}
+	names := make([]string, 0, len(suburbs))
+	for name := range suburbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Sheets parses times in this format as dates when they're entered.
+	t := observed.Local().Format("2006-01-02 15:04:05")
+	var rows [][]interface{}
+	for _, name := range names {
+		s := suburbs[name]
+		var median interface{} = ""
+		if len(s.prices) > 0 {
+			median = collector.Median(s.prices)
+		}
+		rows = append(rows, []interface{}{t, search, name, s.count, median, s.new})
+	}
+	return rows
+}
+
+// write appends a search's suburb summaries in the background.
+func (s *sheetsSink) write(search string, observed time.Time, listings []listingSummary, events []listingEvent) {
+	if s == nil {
+		return
+	}
+	rows := sheetsRows(search, observed, listings, events)
+	if len(rows) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.append(ctx, rows); err != nil {
+			s.writes.WithLabelValues("failure").Inc()
+			slog.Error("error appending to Google Sheets", "search", search, "err", secrets.redact(err.Error()))
+			return
+		}
+		s.writes.WithLabelValues("success").Inc()
+	}()
+}
+
+// append appends rows after the last row of the sheet with the Sheets API,
+// using Application Default Credentials.
+func (s *sheetsSink) append(ctx context.Context, rows [][]interface{}) error {
+	c, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/spreadsheets")
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": rows})
+	if err != nil {
+		return err
+	}
+	// Quote the sheet name, in case it has spaces.
+	a1 := "'" + strings.ReplaceAll(s.sheet, "'", "''") + "'!A1"
+	u := fmt.Sprintf("https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		url.PathEscape(s.spreadsheetID), url.PathEscape(a1))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkUpload(resp)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/mhansen/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// callWindow counts Domain API calls, and how many succeeded, by minute over
+// the last hour, for success ratios over rolling windows.
+type callWindow struct {
+	buckets [60]callBucket
+}
+
+type callBucket struct {
+	minute    int64 // Since the epoch.
+	ok, total float64
+}
+
+func (w *callWindow) add(now time.Time, ok bool) {
+	m := now.Unix() / 60
+	b := &w.buckets[m%int64(len(w.buckets))]
+	if b.minute != m {
+		*b = callBucket{minute: m}
+	}
+	b.total++
+	if ok {
+		b.ok++
+	}
+}
+
+// ratio returns the fraction of calls in the last minutes, counting this one
+// so far, that succeeded, and how many calls there were.
+func (w *callWindow) ratio(now time.Time, minutes int64) (ratio, total float64) {
+	m := now.Unix() / 60
+	var ok float64
+	for _, b := range w.buckets {
+		if b.minute > m-minutes && b.minute <= m {
+			ok += b.ok
+			total += b.total
+		}
+	}
+	if total == 0 {
+		// Nothing to say, rather than everything being fine or broken, so
+		// alerts comparing ratios don't fire.
+		return math.NaN(), 0
+	}
+	return ok / total, total
+}
+
+var (
+	apiSuccessRatio5mDesc = prometheus.NewDesc(
+		"domain_api_success_ratio_5m",
+		"Fraction of Domain API calls in the last 5 minutes that succeeded, NaN if there were none.",
+		nil, nil)
+	apiSuccessRatio1hDesc = prometheus.NewDesc(
+		"domain_api_success_ratio_1h",
+		"Fraction of Domain API calls in the last hour that succeeded, NaN if there were none.",
+		nil, nil)
+	apiCallsWindowDesc = prometheus.NewDesc(
+		"domain_api_window_calls",
+		"Domain API calls in the window the success ratios are over.",
+		[]string{"window"}, nil)
+	apiBurnRateDesc = prometheus.NewDesc(
+		"domain_api_error_budget_burn_rate",
+		"How fast Domain API failures are using up the error budget of --slo.target, where 1 uses it up exactly by the end of the SLO period.",
+		[]string{"window"}, nil)
+	apiSLOTargetDesc = prometheus.NewDesc(
+		"domain_api_slo_target",
+		"The fraction of Domain API calls that should succeed, from --slo.target.",
+		nil, nil)
+)
+
+// sloCollector exports the success ratios of Domain API calls, and the burn
+// rates of the error budget they imply, so the usual multi-window burn rate
+// alerts can be written against the exporter's dependency on Domain.
+type sloCollector struct {
+	h      *health
+	target float64
+}
+
+// Describe implements prometheus.Collector.
+func (c sloCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- apiSuccessRatio5mDesc
+	ch <- apiSuccessRatio1hDesc
+	ch <- apiCallsWindowDesc
+	ch <- apiBurnRateDesc
+	ch <- apiSLOTargetDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c sloCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	c.h.mu.Lock()
+	r5m, n5m := c.h.calls.ratio(now, 5)
+	r1h, n1h := c.h.calls.ratio(now, 60)
+	c.h.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(apiSuccessRatio5mDesc, prometheus.GaugeValue, r5m)
+	ch <- prometheus.MustNewConstMetric(apiSuccessRatio1hDesc, prometheus.GaugeValue, r1h)
+	ch <- prometheus.MustNewConstMetric(apiCallsWindowDesc, prometheus.GaugeValue, n5m, "5m")
+	ch <- prometheus.MustNewConstMetric(apiCallsWindowDesc, prometheus.GaugeValue, n1h, "1h")
+	ch <- prometheus.MustNewConstMetric(apiBurnRateDesc, prometheus.GaugeValue, (1-r5m)/(1-c.target), "5m")
+	ch <- prometheus.MustNewConstMetric(apiBurnRateDesc, prometheus.GaugeValue, (1-r1h)/(1-c.target), "1h")
+	ch <- prometheus.MustNewConstMetric(apiSLOTargetDesc, prometheus.GaugeValue, c.target)
+}
+
+var (
+	searchFreshDesc = prometheus.NewDesc(
+		"domain_search_fresh",
+		"1 if the module's search has succeeded within --slo.freshness, or the exporter started that recently, 0 if it's stale.",
+		[]string{"module"}, nil)
+	searchLastSuccessDesc = prometheus.NewDesc(
+		"domain_search_last_success_timestamp_seconds",
+		"When the module's search last succeeded, if it has since the exporter started.",
+		[]string{"module"}, nil)
+	searchesStaleDesc = prometheus.NewDesc(
+		"domain_searches_stale",
+		"Number of modules whose searches haven't succeeded within --slo.freshness.",
+		nil, nil)
+	searchFreshnessTargetDesc = prometheus.NewDesc(
+		"domain_search_freshness_target_seconds",
+		"How recently each module's search should have succeeded, from --slo.freshness.",
+		nil, nil)
+)
+
+// freshnessCollector exports whether each module's search has succeeded
+// recently enough, and how many haven't, so one alert can catch every stale
+// suburb. Modules that haven't run yet are fresh until target has passed
+// since the exporter started, so a restart doesn't set it off.
+type freshnessCollector struct {
+	dc      domainCollector
+	target  time.Duration
+	started time.Time
+}
+
+// Describe implements prometheus.Collector.
+func (c freshnessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- searchFreshDesc
+	ch <- searchLastSuccessDesc
+	ch <- searchesStaleDesc
+	ch <- searchFreshnessTargetDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c freshnessCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	stale := 0
+	for _, module := range c.dc.searches.names() {
+		since := c.started
+		if updated, ok := c.dc.seen.lastRun(searchKey(module, domain.ResidentialSearchRequest{})); ok {
+			since = updated
+			ch <- prometheus.MustNewConstMetric(searchLastSuccessDesc, prometheus.GaugeValue, float64(updated.UnixNano())/1e9, module)
+		}
+		fresh := 1.0
+		if now.Sub(since) > c.target {
+			fresh = 0
+			stale++
+		}
+		ch <- prometheus.MustNewConstMetric(searchFreshDesc, prometheus.GaugeValue, fresh, module)
+	}
+	ch <- prometheus.MustNewConstMetric(searchesStaleDesc, prometheus.GaugeValue, float64(stale))
+	ch <- prometheus.MustNewConstMetric(searchFreshnessTargetDesc, prometheus.GaugeValue, c.target.Seconds())
+}
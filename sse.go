@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// sseHeartbeatInterval is how often an idle event stream gets a comment, to
+// keep proxies from closing it.
+const sseHeartbeatInterval = 30 * time.Second
+
+// sseEventsHandler streams a search's listing events as Server-Sent Events,
+// as the search runs, with the event type as the SSE event name. Pick the
+// search with the same parameters as /listings, and the types of events with
+// ?types=.
+func (dc domainCollector) sseEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	module, rsr, err := dc.searchFromQuery(q, "types")
+	if err != nil {
+		dc.badRequest(w, err)
+		return
+	}
+	filter, err := watchFilterFromQuery(q)
+	if err != nil {
+		dc.badRequest(w, badParam(reasonInvalidValue, "types", q.Get("types"), "%v", err))
+		return
+	}
+	filter.searches = []string{searchKey(module, rsr)}
+	events, stop := dc.hub.watch()
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// Tell the client the stream is open straight away.
+	fmt.Fprintf(w, ": watching %s\n\n", filter.searches[0])
+	if err := rc.Flush(); err != nil {
+		slog.Error("can't stream events, the response can't be flushed", "err", err)
+		return
+	}
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("error encoding event", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsdMaxPacket keeps StatsD packets under a typical MTU.
+const statsdMaxPacket = 1432
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", " ", "_")
+
+// statsdWriter sends metrics to StatsD as gauges over UDP. With DogStatsD,
+// labels are sent as tags; otherwise they're part of the metric name, like
+// <prefix><module>.<metric>.<label>.<value>....
+type statsdWriter struct {
+	addr   string
+	prefix string
+	tags   bool
+}
+
+// Push implements pusher.
+func (sw *statsdWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	var extra map[string]string
+	if sw.tags {
+		extra = map[string]string{"module": module}
+	}
+	samples, err := gatherSamples(g, extra)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", sw.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet []byte
+	for _, s := range samples {
+		var line string
+		if sw.tags {
+			var tags []string
+			for _, l := range s.Labels {
+				tags = append(tags, statsdEscaper.Replace(l.Name)+":"+statsdEscaper.Replace(l.Value))
+			}
+			line = sw.prefix + s.Name + ":" + strconv.FormatFloat(s.Value, 'f', -1, 64) + "|g"
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+		} else {
+			name := []string{graphiteUnsafe.ReplaceAllString(module, "_"), s.Name}
+			for _, l := range s.Labels {
+				v := graphiteUnsafe.ReplaceAllString(l.Value, "_")
+				if v == "" {
+					v = "none"
+				}
+				name = append(name, l.Name, v)
+			}
+			line = sw.prefix + strings.Join(name, ".") + ":" + strconv.FormatFloat(s.Value, 'f', -1, 64) + "|g"
+		}
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		_, err = conn.Write(packet)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// natsNotifier publishes each event as JSON to a NATS subject named after the
+// event type, like domain_exporter.listings.new.
+type natsNotifier struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func newNATSNotifier(url, prefix string) (*natsNotifier, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("domain_exporter"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsNotifier{conn: conn, prefix: prefix}, nil
+}
+
+// Notify implements notifier.
+func (n *natsNotifier) Notify(ctx context.Context, events []listingEvent) error {
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := n.conn.Publish(n.prefix+"."+e.Type, b); err != nil {
+			return err
+		}
+	}
+	return n.conn.FlushTimeout(10 * time.Second)
+}
+
+// kafkaNotifier writes each event as JSON to a Kafka topic, keyed by listing
+// ID so that the events for a listing stay in order.
+type kafkaNotifier struct {
+	w *kafka.Writer
+}
+
+func newKafkaNotifier(brokers, topic string) *kafkaNotifier {
+	return &kafkaNotifier{w: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		WriteTimeout: 10 * time.Second,
+	}}
+}
+
+// Notify implements notifier.
+func (n *kafkaNotifier) Notify(ctx context.Context, events []listingEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:     []byte(strconv.Itoa(int(e.Listing.ID))),
+			Value:   b,
+			Headers: []kafka.Header{{Key: "type", Value: []byte(e.Type)}},
+		})
+	}
+	if err := n.w.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("couldn't write to kafka: %v", err)
+	}
+	return nil
+}
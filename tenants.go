@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	reasonUnknownTenant    = "unknown_tenant"
+	reasonTenantNotAllowed = "tenant_not_allowed"
+)
+
+type tenantKey struct{}
+
+// tenantConfig is a team sharing the exporter, whose searches are made with
+// its own Domain API keys, so they come out of its own quota.
+type tenantConfig struct {
+	Name string `json:"name"`
+	// Keys are API keys, or secret manager URIs for them.
+	Keys []string `json:"keys"`
+	// Modules are searches in --searches_dir made for this tenant, unless a
+	// request says otherwise.
+	Modules []string `json:"modules"`
+	// Tokens are the bearer tokens, or secret manager URIs for them, of
+	// requests allowed to choose this tenant by header. Without any, only
+	// its modules use its keys.
+	Tokens []string `json:"tokens"`
+}
+
+// tenantSet is the tenants in --tenants.file.
+type tenantSet struct {
+	header   string
+	keys     map[string][]string
+	tokens   map[string][]string
+	byModule map[string]string
+}
+
+var tenantNameRE = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// readTenants reads a JSON list of tenantConfigs from path, fetching any keys
+// in secret managers.
+func readTenants(ctx context.Context, path, header string) (*tenantSet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []tenantConfig
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %v", path, err)
+	}
+	ts := &tenantSet{header: header, keys: map[string][]string{}, tokens: map[string][]string{}, byModule: map[string]string{}}
+	for i, c := range configs {
+		if !tenantNameRE.MatchString(c.Name) {
+			return nil, fmt.Errorf("tenant %d has a bad name %q, want letters, digits, _, . or -", i, c.Name)
+		}
+		if ts.keys[c.Name] != nil {
+			return nil, fmt.Errorf("tenant %q is defined twice", c.Name)
+		}
+		var keys []string
+		for _, k := range c.Keys {
+			v, err := resolveSecret(ctx, k)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: couldn't fetch secret %s: %v", c.Name, k, err)
+			}
+			keys = append(keys, parseAPIKeys(v)...)
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("tenant %q has no API keys", c.Name)
+		}
+		secrets.add(keys...)
+		ts.keys[c.Name] = keys
+		for _, t := range c.Tokens {
+			v, err := resolveSecret(ctx, t)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: couldn't fetch secret %s: %v", c.Name, t, err)
+			}
+			if v = strings.TrimSpace(v); v != "" {
+				ts.tokens[c.Name] = append(ts.tokens[c.Name], v)
+			}
+		}
+		secrets.add(ts.tokens[c.Name]...)
+		for _, m := range c.Modules {
+			if t, ok := ts.byModule[m]; ok {
+				return nil, fmt.Errorf("module %q belongs to both tenants %q and %q", m, t, c.Name)
+			}
+			ts.byModule[m] = c.Name
+		}
+	}
+	return ts, nil
+}
+
+// wrap returns h, taking the tenant of each request from its header.
+// Requests naming a tenant that doesn't exist, or without one of its tokens,
+// are rejected, rather than silently spending the default keys' quota, or
+// another team's.
+func (ts *tenantSet) wrap(dc domainCollector, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(ts.header)
+		if name == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if err := ts.choose(name, r.Header.Get("Authorization"), "header"); err != nil {
+			dc.badRequest(w, err)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(withTenant(r.Context(), name)))
+	})
+}
+
+// choose checks that a request with an Authorization header value can use
+// the named tenant's keys, which it named in where.
+func (ts *tenantSet) choose(name, authorization, where string) *paramError {
+	if ts.keys[name] == nil {
+		return &paramError{
+			Message: fmt.Sprintf("unknown tenant %q in the %s %s", name, ts.header, where),
+			Reason:  reasonUnknownTenant,
+			Value:   name,
+		}
+	}
+	scheme, cred, _ := strings.Cut(authorization, " ")
+	ok := false
+	if strings.EqualFold(scheme, "bearer") {
+		for _, t := range ts.tokens[name] {
+			// Check every token, so the time taken doesn't say which matched.
+			if subtle.ConstantTimeCompare([]byte(cred), []byte(t)) == 1 {
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		return &paramError{
+			Message: fmt.Sprintf("tenant %q needs one of its bearer tokens", name),
+			Reason:  reasonTenantNotAllowed,
+			Value:   name,
+			code:    http.StatusForbidden,
+		}
+	}
+	return nil
+}
+
+// forModule returns ctx with the tenant of module, unless ctx already has
+// one. The set may be nil.
+func (ts *tenantSet) forModule(ctx context.Context, module string) context.Context {
+	if ts == nil || tenant(ctx) != "" {
+		return ctx
+	}
+	if t, ok := ts.byModule[module]; ok {
+		return withTenant(ctx, t)
+	}
+	return ctx
+}
+
+// withTenant returns ctx making Domain API calls with the named tenant's keys.
+func withTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, name)
+}
+
+// tenant returns the tenant whose keys calls made with ctx use, or "" for the
+// default keys.
+func tenant(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// tenantTransport sends each Domain API request through its tenant's
+// keyTransport, or the default transport if it has none.
+type tenantTransport struct {
+	// def is nil if there are no default credentials, and every request
+	// needs a tenant.
+	def      http.RoundTripper
+	tenants  map[string]http.RoundTripper
+	requests *prometheus.CounterVec
+	// keyRequests is domain_api_key_requests_total, shared by the tenants'
+	// keyTransports and the default one, if any.
+	keyRequests *prometheus.CounterVec
+}
+
+// newTenantTransport returns a tenantTransport with a keyTransport over base
+// for each tenant, counting requests in the same metric as kt, if given.
+func newTenantTransport(base, def http.RoundTripper, ts *tenantSet, kt *keyTransport, roundRobin bool) *tenantTransport {
+	t := &tenantTransport{
+		def:     def,
+		tenants: map[string]http.RoundTripper{},
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "domain_tenant_requests_total",
+				Help: "Requests made to the Domain API with a tenant's keys, by tenant and HTTP status code, or error if there was no response.",
+			},
+			[]string{"tenant", "code"},
+		),
+	}
+	if kt != nil {
+		t.keyRequests = kt.requests
+	}
+	for name, keys := range ts.keys {
+		tkt := newKeyTransport(base, staticKeys(keys), roundRobin)
+		if t.keyRequests == nil {
+			t.keyRequests = tkt.requests
+		}
+		tkt.requests = t.keyRequests
+		t.tenants[name] = tkt
+	}
+	return t
+}
+
+func (t *tenantTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := tenant(req.Context())
+	if name == "" {
+		if t.def == nil {
+			return nil, fmt.Errorf("no tenant given for this search, and there are no default API keys")
+		}
+		return t.def.RoundTrip(req)
+	}
+	resp, err := t.tenants[name].RoundTrip(req)
+	if err != nil {
+		t.requests.WithLabelValues(name, "error").Inc()
+		return nil, err
+	}
+	t.requests.WithLabelValues(name, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
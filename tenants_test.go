@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeTenants(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTenants(t *testing.T) {
+	for _, tc := range []struct {
+		name, contents string
+		wantErr        string
+	}{
+		{name: "ok", contents: `[{"name": "a", "keys": ["k1"], "modules": ["m1"], "tokens": ["t1"]}, {"name": "b", "keys": ["k2,k3"]}]`},
+		{name: "bad name", contents: `[{"name": "a b", "keys": ["k1"]}]`, wantErr: "bad name"},
+		{name: "twice", contents: `[{"name": "a", "keys": ["k1"]}, {"name": "a", "keys": ["k2"]}]`, wantErr: "defined twice"},
+		{name: "no keys", contents: `[{"name": "a", "keys": [" "]}]`, wantErr: "no API keys"},
+		{name: "shared module", contents: `[{"name": "a", "keys": ["k1"], "modules": ["m"]}, {"name": "b", "keys": ["k2"], "modules": ["m"]}]`, wantErr: "belongs to both"},
+		{name: "unknown field", contents: `[{"name": "a", "keys": ["k1"], "token": "t"}]`, wantErr: "unknown field"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readTenants(context.Background(), writeTenants(t, tc.contents), "X-Domain-Tenant")
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("readTenants() = %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("readTenants() = %v, want an error with %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenantSetWrap(t *testing.T) {
+	ts, err := readTenants(context.Background(), writeTenants(t, `[
+		{"name": "a", "keys": ["ka"], "tokens": ["ta", "ta2"]},
+		{"name": "b", "keys": ["kb"], "tokens": ["tb"]},
+		{"name": "c", "keys": ["kc"]}
+	]`), "X-Domain-Tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := domainCollector{badRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "bad_requests_total"}, []string{"reason"})}
+	for _, tc := range []struct {
+		name, tenant, authorization string
+		wantCode                    int
+		wantTenant                  string
+	}{
+		{name: "no tenant", authorization: "Bearer ta", wantCode: 200},
+		{name: "own tenant", tenant: "a", authorization: "Bearer ta", wantCode: 200, wantTenant: "a"},
+		{name: "own tenant, other token", tenant: "a", authorization: "Bearer ta2", wantCode: 200, wantTenant: "a"},
+		{name: "other team's tenant", tenant: "b", authorization: "Bearer ta", wantCode: 403},
+		{name: "no token", tenant: "a", wantCode: 403},
+		{name: "basic auth", tenant: "a", authorization: "Basic dGE6dGE=", wantCode: 403},
+		{name: "tenant without tokens", tenant: "c", authorization: "Bearer ", wantCode: 403},
+		{name: "unknown tenant", tenant: "d", authorization: "Bearer ta", wantCode: 400},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			h := ts.wrap(dc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = tenant(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/listings?module=m", nil)
+			if tc.tenant != "" {
+				r.Header.Set("X-Domain-Tenant", tc.tenant)
+			}
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.wantCode {
+				t.Errorf("got %d, want %d", w.Code, tc.wantCode)
+			}
+			if got != tc.wantTenant {
+				t.Errorf("tenant = %q, want %q", got, tc.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantTransport(t *testing.T) {
+	ts := &tenantSet{keys: map[string][]string{"a": {"ka"}, "down": {"kd"}}}
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-Api-Key") == "kd" {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	def := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 204, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	for _, tc := range []struct {
+		name     string
+		tenant   string
+		def      http.RoundTripper
+		wantCode int
+		wantErr  bool
+	}{
+		{name: "tenant", tenant: "a", wantCode: 200},
+		{name: "default", def: def, wantCode: 204},
+		{name: "no default", wantErr: true},
+		{name: "error", tenant: "down", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTenantTransport(base, tc.def, ts, nil, false)
+			ctx := context.Background()
+			if tc.tenant != "" {
+				ctx = withTenant(ctx, tc.tenant)
+			}
+			req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.domain.com.au/v1/listings/1", nil)
+			resp, err := tt.RoundTrip(req)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("RoundTrip() = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && resp.StatusCode != tc.wantCode {
+				t.Errorf("got %d, want %d", resp.StatusCode, tc.wantCode)
+			}
+			if tc.tenant == "" {
+				if n := testutil.CollectAndCount(tt.requests); n != 0 {
+					t.Errorf("%d domain_tenant_requests_total series for a request without a tenant", n)
+				}
+				return
+			}
+			code := "200"
+			if tc.wantErr {
+				code = "error"
+			}
+			if got := testutil.ToFloat64(tt.requests.WithLabelValues(tc.tenant, code)); got != 1 {
+				t.Errorf("domain_tenant_requests_total{tenant=%q,code=%q} = %v, want 1", tc.tenant, code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// textfileWriter writes each module's metrics to
+// <dir>/domain_exporter_<module>.prom, for node_exporter's textfile collector.
+type textfileWriter struct {
+	dir string
+}
+
+// Push implements pusher. The file is replaced atomically, so node_exporter
+// never reads half of it.
+func (tw *textfileWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(tw.dir, ".domain_exporter_*.prom.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("module"), Value: proto.String(module)})
+		}
+		if _, err := expfmt.MetricFamilyToText(f, mf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// node_exporter runs as a different user, so make sure it can read the
+	// file despite CreateTemp's 0600.
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(tw.dir, "domain_exporter_"+unsafePathChars.ReplaceAllString(module, "_")+".prom"))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// transportOptions tunes the connection pool of the transport used to talk to
+// the Domain API.
+type transportOptions struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+	DisableKeepAlives   bool
+}
+
+// newTransport returns the transport used to talk to the Domain API.
+func newTransport(opts transportOptions) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = opts.MaxIdleConns
+	t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	t.IdleConnTimeout = opts.IdleConnTimeout
+	t.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	t.DisableKeepAlives = opts.DisableKeepAlives
+	return t
+}
+
+// connTraceTransport counts whether each request got a new connection or
+// reused an idle one.
+type connTraceTransport struct {
+	base        http.RoundTripper
+	connections *prometheus.CounterVec
+}
+
+func newConnTraceTransport(base http.RoundTripper) *connTraceTransport {
+	return &connTraceTransport{
+		base: base,
+		connections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "domain_http_connections_total",
+				Help: "Connections used for Domain API requests, by whether they were reused from the idle pool.",
+			},
+			[]string{"reused"},
+		),
+	}
+}
+
+func (t *connTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.connections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// configureProxy makes t send requests through proxyURL, except for hosts
+// matching noProxy.
+func configureProxy(t *http.Transport, proxyURL, noProxy string, reg prometheus.Registerer) {
+	requests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "domain_proxy_requests_total",
+			Help: "Requests sent through the outbound proxy.",
+		},
+		[]string{"proxy"},
+	)
+	connects := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "domain_proxy_connects_total",
+			Help: "CONNECT requests made to the outbound proxy, by HTTP status code.",
+		},
+		[]string{"proxy", "code"},
+	)
+	reg.MustRegister(requests, connects)
+
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req.URL)
+		if u != nil {
+			requests.WithLabelValues(u.Host).Inc()
+		}
+		return u, err
+	}
+	t.OnProxyConnectResponse = func(ctx context.Context, proxyURL *url.URL, req *http.Request, resp *http.Response) error {
+		connects.WithLabelValues(proxyURL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+		return nil
+	}
+}
+
+// roundTripperFunc lets an ordinary function be used as an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newEndpointTransport sends requests that the domain client makes to
+// https://api.domain.com.au/v1/... to baseURL/version/... instead.
+func newEndpointTransport(rt http.RoundTripper, baseURL, version string) (http.RoundTripper, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("API URL %q must be absolute", baseURL)
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "api.domain.com.au" {
+			return rt.RoundTrip(req)
+		}
+		req = req.Clone(req.Context())
+		req.URL.Scheme = base.Scheme
+		req.URL.Host = base.Host
+		req.URL.Path = strings.TrimRight(base.Path, "/") + "/" + version + "/" + strings.TrimPrefix(req.URL.Path, "/v1/")
+		req.Host = ""
+		return rt.RoundTrip(req)
+	}), nil
+}
+
+// withHeader returns a transport that sets a header on every request.
+func withHeader(rt http.RoundTripper, key, value string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set(key, value)
+		return rt.RoundTrip(req)
+	})
+}
+
+// fixturePath returns where a response to req is stored under dir. Each
+// distinct request body (e.g. each page of a search) gets its own file, so
+// that recordings can be replayed exactly.
+func fixturePath(dir string, req *http.Request) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		h.Write(b)
+	}
+	return fmt.Sprintf("%s-%x.json", filepath.Join(dir, filepath.FromSlash(req.URL.Path)), h.Sum(nil)[:6]), nil
+}
+
+// mockTransport answers Domain API requests from JSON fixtures on disk, so
+// the exporter can run without an API key. A request for
+// https://api.domain.com.au/v1/listings/residential/_search is answered with
+// the file recordTransport saved for that exact request if there is one, or
+// else the contents of <dir>/v1/listings/residential/_search.json.
+type mockTransport struct {
+	dir string
+}
+
+func (t mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := fixturePath(t.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	f, err := os.Open(recorded)
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(t.dir, filepath.FromSlash(req.URL.Path)+".json"))
+	}
+	if os.IsNotExist(err) {
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       f,
+		Request:    req,
+	}, nil
+}
+
+// recordTransport saves every successful Domain API response under dir, in
+// the layout mockTransport reads back.
+type recordTransport struct {
+	dir  string
+	base http.RoundTripper
+}
+
+func (t recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, err := fixturePath(t.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Error("couldn't record response", "err", err)
+		return resp, nil
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		slog.Error("couldn't record response", "err", err)
+		return resp, nil
+	}
+	slog.Debug("Recorded response", "path", path)
+	return resp, nil
+}
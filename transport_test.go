@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEndpointTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name, baseURL, version, url string
+		want                        string
+		wantErr                     bool
+	}{
+		{name: "default", baseURL: "https://api.domain.com.au", version: "v1", url: "https://api.domain.com.au/v1/listings/residential/_search", want: "https://api.domain.com.au/v1/listings/residential/_search"},
+		{name: "sandbox", baseURL: "https://api.sandbox.example.com", version: "v2", url: "https://api.domain.com.au/v1/listings/1", want: "https://api.sandbox.example.com/v2/listings/1"},
+		{name: "gateway with a path", baseURL: "http://gateway:8080/domain/", version: "v1", url: "https://api.domain.com.au/v1/listings/1?x=1", want: "http://gateway:8080/domain/v1/listings/1?x=1"},
+		{name: "other hosts", baseURL: "http://gateway:8080", version: "v1", url: "https://auth.domain.com.au/v1/connect/token", want: "https://auth.domain.com.au/v1/connect/token"},
+		{name: "relative", baseURL: "gateway/domain", version: "v1", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			rt, err := newEndpointTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				got = req.URL.String()
+				if req.Host != "" && req.Host != req.URL.Host {
+					t.Errorf("Host = %q, want %q from the URL", req.Host, req.URL.Host)
+				}
+				return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+			}), tc.baseURL, tc.version)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("newEndpointTransport() = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			if _, err := rt.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("sent to %s, want %s", got, tc.want)
+			}
+			if req.URL.String() != tc.url {
+				t.Errorf("changed the caller's request to %s", req.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// trendWindows are the moving average windows exported by trendCollector.
+var trendWindows = []struct {
+	name string
+	days int
+}{{"7d", 7}, {"28d", 28}}
+
+// trendsTTL is how long trendCollector reuses its averages. They're over
+// days, so recomputing them on every scrape would only load the database.
+const trendsTTL = time.Hour
+
+var (
+	listingCountAvgDesc = prometheus.NewDesc(
+		"domain_listing_count_moving_average",
+		"Average number of listings a search found per day, over the window, from the history database.",
+		[]string{"search", "window"}, nil,
+	)
+	medianPriceAvgDesc = prometheus.NewDesc(
+		"domain_listing_median_price_moving_average",
+		"Average of a search's daily median price, over the window, from the history database.",
+		[]string{"search", "window"}, nil,
+	)
+)
+
+// trendCollector exports moving averages of each search's listing count and
+// median price, so trend dashboards don't need long Prometheus retention.
+type trendCollector struct {
+	h *historyStore
+
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+	expires time.Time
+}
+
+func newTrendCollector(h *historyStore) *trendCollector {
+	return &trendCollector{h: h}
+}
+
+// Describe implements prometheus.Collector.
+func (c *trendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listingCountAvgDesc
+	ch <- medianPriceAvgDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *trendCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.After(c.expires) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		metrics, err := c.compute(ctx, now)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(listingCountAvgDesc, fmt.Errorf("error reading history: %v", err))
+			return
+		}
+		c.metrics, c.expires = metrics, now.Add(trendsTTL)
+	}
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+// compute returns the moving averages for every search with history.
+func (c *trendCollector) compute(ctx context.Context, now time.Time) ([]prometheus.Metric, error) {
+	searches, err := c.h.searches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	longest := trendWindows[len(trendWindows)-1].days
+	var metrics []prometheus.Metric
+	for _, search := range searches {
+		snapshots, err := c.h.snapshots(ctx, search, now.AddDate(0, 0, -longest), now)
+		if err != nil {
+			return nil, err
+		}
+		days := dailyAverages(summarizeSnapshots(snapshots))
+		for _, w := range trendWindows {
+			from := now.AddDate(0, 0, -w.days)
+			var n, priced int
+			var count, price float64
+			for _, d := range days {
+				if d.time.Before(from) {
+					continue
+				}
+				n++
+				count += d.count
+				if d.medianPrice != 0 {
+					priced++
+					price += d.medianPrice
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			metrics = append(metrics, prometheus.MustNewConstMetric(listingCountAvgDesc, prometheus.GaugeValue, count/float64(n), search, w.name))
+			if priced > 0 {
+				metrics = append(metrics, prometheus.MustNewConstMetric(medianPriceAvgDesc, prometheus.GaugeValue, price/float64(priced), search, w.name))
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// trendDay is the average of a search's historyPoints on one day.
+type trendDay struct {
+	time        time.Time // The day's last point.
+	count       float64
+	medianPrice float64
+}
+
+// dailyAverages averages points, which must be sorted by time, into one per
+// UTC day, so days when a search ran more often don't count for more.
+func dailyAverages(points []historyPoint) []trendDay {
+	var days []trendDay
+	var runs, priced int
+	flush := func() {
+		if runs == 0 {
+			return
+		}
+		d := &days[len(days)-1]
+		d.count /= float64(runs)
+		if priced > 0 {
+			d.medianPrice /= float64(priced)
+		}
+	}
+	for i, p := range points {
+		if i == 0 || p.Time.UTC().Truncate(24*time.Hour) != points[i-1].Time.UTC().Truncate(24*time.Hour) {
+			flush()
+			days = append(days, trendDay{})
+			runs, priced = 0, 0
+		}
+		d := &days[len(days)-1]
+		d.time = p.Time
+		runs++
+		d.count += float64(p.Count)
+		if p.MedianPrice != 0 {
+			priced++
+			d.medianPrice += p.MedianPrice
+		}
+	}
+	flush()
+	return days
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watcherBuffer is how many events can be waiting for a watcher before it
+// misses some.
+const watcherBuffer = 100
+
+// eventHub fans listing events out to clients watching them live. Slow
+// watchers miss events, rather than holding up searches.
+type eventHub struct {
+	mu       sync.Mutex
+	watchers map[chan listingEvent]bool
+	closed   bool
+	dropped  prometheus.Counter
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		watchers: map[chan listingEvent]bool{},
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "domain_watch_events_dropped_total",
+			Help: "Number of listing events not sent to a live watcher because it fell behind.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (h *eventHub) Describe(ch chan<- *prometheus.Desc) {
+	h.dropped.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (h *eventHub) Collect(ch chan<- prometheus.Metric) {
+	h.dropped.Collect(ch)
+}
+
+// watch returns a channel of events published from now on, and a function to
+// call to stop watching. The channel is closed when the hub is.
+func (h *eventHub) watch() (<-chan listingEvent, func()) {
+	c := make(chan listingEvent, watcherBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return c, func() {}
+	}
+	h.watchers[c] = true
+	return c, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.watchers[c] {
+			delete(h.watchers, c)
+			close(c)
+		}
+	}
+}
+
+// close ends every watch, so that streaming responses finish when the
+// exporter shuts down.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.watchers {
+		delete(h.watchers, c)
+		close(c)
+	}
+}
+
+// publish sends events to every watcher.
+func (h *eventHub) publish(events []listingEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.watchers {
+		for _, e := range events {
+			select {
+			case c <- e:
+			default:
+				h.dropped.Inc()
+			}
+		}
+	}
+}
+
+// watchFilter picks out the events a watcher asked for. Empty lists match
+// everything.
+type watchFilter struct {
+	types    []string
+	searches []string
+}
+
+func newWatchFilter(types, searches []string) (watchFilter, error) {
+	for _, t := range types {
+		if !slices.Contains(eventTypes, t) {
+			return watchFilter{}, fmt.Errorf("unknown event type %q, want one of %s", t, strings.Join(eventTypes, ", "))
+		}
+	}
+	return watchFilter{types: types, searches: searches}, nil
+}
+
+func (f watchFilter) match(e listingEvent) bool {
+	return (len(f.types) == 0 || slices.Contains(f.types, e.Type)) &&
+		(len(f.searches) == 0 || slices.Contains(f.searches, e.Search))
+}
+
+// watchFilterFromQuery returns the filter asked for by ?types=, a comma
+// separated list of event types, and ?search=, which can be repeated.
+func watchFilterFromQuery(q url.Values) (watchFilter, error) {
+	var types []string
+	for _, t := range strings.Split(q.Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return newWatchFilter(types, q["search"])
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPingInterval is how often idle WebSocket connections are pinged, to keep
+// proxies from closing them and to notice clients that have gone away.
+const wsPingInterval = 30 * time.Second
+
+// wsUpgrader only accepts connections from pages served by the exporter
+// itself, the default, so other sites can't read events through a browser,
+// and from --web.cors-origins.
+var wsUpgrader = websocket.Upgrader{}
+
+// wsEventsHandler streams listing events as JSON messages over a WebSocket,
+// as searches find them. ?types= and ?search= pick which events to send.
+func (dc domainCollector) wsEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := watchFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with the error.
+		return
+	}
+	defer conn.Close()
+	events, stop := dc.hub.watch()
+	defer stop()
+
+	// Clients don't send anything, but reading handles pongs and notices
+	// when they close the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(e); err != nil {
+				slog.Debug("error writing to WebSocket", "err", err)
+				return
+			}
+		}
+	}
+}